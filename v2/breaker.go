@@ -0,0 +1,241 @@
+package toolkit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults used by CircuitBreaker when its fields are not set.
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCoolDown  = 30 * time.Second
+)
+
+// CircuitState is the state of the circuit for one host.
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // requests are sent, and failures counted
+	CircuitOpen                         // requests fail at once with ErrCircuitOpen
+	CircuitHalfOpen                     // a limited number of trial requests are sent
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitOpenError is returned for a request that is not sent because the circuit for its host
+// is open, or because the trial requests allowed while it is half-open are already in flight.
+type CircuitOpenError struct {
+	Host string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %s is open", e.Host)
+}
+
+func (e *CircuitOpenError) Unwrap() error   { return ErrCircuitOpen }
+func (e *CircuitOpenError) HTTPStatus() int { return http.StatusServiceUnavailable }
+
+// CircuitBreaker stops outbound requests made by DoJSON and PushJSONToRemote from waiting on a
+// host that keeps failing. Each host has its own circuit, which opens after FailureThreshold
+// consecutive failures; requests to it then fail at once with a *CircuitOpenError until CoolDown
+// has passed. The circuit is then half-open: up to HalfOpenRequests trial requests are sent, and
+// it closes once that many succeed in a row, or opens again as soon as one fails.
+//
+// When a RetryPolicy is also set, every attempt goes through the breaker, and a request is not
+// retried once the circuit is open. A CircuitBreaker must not be copied after first use.
+type CircuitBreaker struct {
+	FailureThreshold int           // consecutive failures that open the circuit; 0 means 5
+	CoolDown         time.Duration // how long the circuit stays open; 0 means 30s
+	HalfOpenRequests int           // trial requests while half-open; 0 means 1
+
+	// IsFailure decides whether an attempt counts as a failure of the host. err is the error from
+	// sending the request, or nil if resp holds the response. nil means DefaultIsFailure.
+	IsFailure func(resp *http.Response, err error) bool
+
+	// OnStateChange, if set, is called whenever the circuit for a host changes state. It is called
+	// without holding the breaker's lock, so it may be called concurrently.
+	OnStateChange func(host string, from, to CircuitState)
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+// circuit is the state of one host. gen changes with every state change, so that results of
+// requests admitted under an earlier state are not counted against the current one.
+type circuit struct {
+	state     CircuitState
+	gen       int
+	failures  int
+	successes int
+	trials    int
+	openedAt  time.Time
+}
+
+// DefaultIsFailure counts connection errors and 5xx responses as failures.
+func DefaultIsFailure(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+// State returns the state of the circuit for host. An open circuit is reported as open until a
+// request is made after its cool-down.
+func (b *CircuitBreaker) State(host string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.hosts[host]; ok {
+		return c.state
+	}
+
+	return CircuitClosed
+}
+
+// do sends the request with send if the circuit for host allows it, and records the result.
+// Requests whose context has ended are not counted either way.
+func (b *CircuitBreaker) do(ctx context.Context, clock Clock, host string, send func() (*http.Response, error)) (*http.Response, error) {
+	gen, err := b.allow(clock, host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := send()
+
+	isFailure := b.IsFailure
+	if isFailure == nil {
+		isFailure = DefaultIsFailure
+	}
+	b.record(clock, host, gen, ctx.Err() != nil, isFailure(resp, err))
+
+	return resp, err
+}
+
+// allow reports whether a request to host may be sent, returning the generation of its circuit.
+func (b *CircuitBreaker) allow(clock Clock, host string) (int, error) {
+	b.mu.Lock()
+
+	if b.hosts == nil {
+		b.hosts = make(map[string]*circuit)
+	}
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{}
+		b.hosts[host] = c
+	}
+
+	from := c.state
+	if c.state == CircuitOpen && clock.Now().Sub(c.openedAt) >= b.coolDown() {
+		b.setState(c, CircuitHalfOpen, clock)
+	}
+	if c.state == CircuitOpen || (c.state == CircuitHalfOpen && c.trials >= b.halfOpenRequests()) {
+		to := c.state
+		b.mu.Unlock()
+		b.notify(host, from, to)
+		return 0, &CircuitOpenError{Host: host}
+	}
+	if c.state == CircuitHalfOpen {
+		c.trials++
+	}
+	gen, to := c.gen, c.state
+
+	b.mu.Unlock()
+	b.notify(host, from, to)
+
+	return gen, nil
+}
+
+// record counts the result of a request admitted under generation gen. A cancelled request only
+// gives back its trial slot.
+func (b *CircuitBreaker) record(clock Clock, host string, gen int, cancelled, failed bool) {
+	b.mu.Lock()
+
+	c := b.hosts[host]
+	if c.gen != gen {
+		b.mu.Unlock()
+		return
+	}
+
+	from := c.state
+	switch c.state {
+	case CircuitClosed:
+		switch {
+		case cancelled:
+		case failed:
+			c.failures++
+			if c.failures >= b.failureThreshold() {
+				b.setState(c, CircuitOpen, clock)
+			}
+		default:
+			c.failures = 0
+		}
+	case CircuitHalfOpen:
+		c.trials--
+		switch {
+		case cancelled:
+		case failed:
+			b.setState(c, CircuitOpen, clock)
+		default:
+			c.successes++
+			if c.successes >= b.halfOpenRequests() {
+				b.setState(c, CircuitClosed, clock)
+			}
+		}
+	}
+	to := c.state
+
+	b.mu.Unlock()
+	b.notify(host, from, to)
+}
+
+// setState moves c to state, resetting its counters. The caller must hold the lock.
+func (b *CircuitBreaker) setState(c *circuit, state CircuitState, clock Clock) {
+	c.state = state
+	c.gen++
+	c.failures, c.successes, c.trials = 0, 0, 0
+	if state == CircuitOpen {
+		c.openedAt = clock.Now()
+	}
+}
+
+// notify calls OnStateChange if the state has changed.
+func (b *CircuitBreaker) notify(host string, from, to CircuitState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(host, from, to)
+	}
+}
+
+func (b *CircuitBreaker) failureThreshold() int {
+	if b.FailureThreshold > 0 {
+		return b.FailureThreshold
+	}
+
+	return defaultBreakerThreshold
+}
+
+func (b *CircuitBreaker) coolDown() time.Duration {
+	if b.CoolDown > 0 {
+		return b.CoolDown
+	}
+
+	return defaultBreakerCoolDown
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.HalfOpenRequests > 0 {
+		return b.HalfOpenRequests
+	}
+
+	return 1
+}
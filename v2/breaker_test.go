@@ -0,0 +1,150 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTools_CircuitBreaker(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	calls := 0
+	clock := newFakeClock()
+	var changes []string
+
+	testTools := Tools{
+		Clock: clock,
+		HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+			calls++
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(``)), Header: make(http.Header)}
+		}),
+		CircuitBreaker: &CircuitBreaker{
+			FailureThreshold: 3,
+			CoolDown:         time.Minute,
+			OnStateChange: func(host string, from, to CircuitState) {
+				changes = append(changes, fmt.Sprintf("%s %s->%s", host, from, to))
+			},
+		},
+	}
+	push := func(uri string) error {
+		_, _, err := testTools.PushJSONToRemote(uri, nil)
+		return err
+	}
+
+	// reaching the failure threshold opens the circuit for that host only
+	for range 3 {
+		assert.NoError(t, push("http://a.example.com"))
+	}
+	assert.Equal(t, CircuitOpen, testTools.CircuitBreaker.State("a.example.com"))
+	assert.Equal(t, CircuitClosed, testTools.CircuitBreaker.State("b.example.com"))
+	assert.Equal(t, 3, calls)
+
+	// while open, requests fail fast, but other hosts are unaffected
+	err := push("http://a.example.com")
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	var openErr *CircuitOpenError
+	if assert.ErrorAs(t, err, &openErr) {
+		assert.Equal(t, "a.example.com", openErr.Host)
+	}
+	assert.Equal(t, 3, calls)
+	assert.NoError(t, push("http://b.example.com"))
+	assert.Equal(t, 4, calls)
+
+	// after the cool-down a failing trial opens the circuit again
+	clock.Advance(time.Minute)
+	assert.NoError(t, push("http://a.example.com"))
+	assert.Equal(t, 5, calls)
+	assert.ErrorIs(t, push("http://a.example.com"), ErrCircuitOpen)
+
+	// and a successful one closes it
+	clock.Advance(time.Minute)
+	status = http.StatusOK
+	assert.NoError(t, push("http://a.example.com"))
+	assert.Equal(t, CircuitClosed, testTools.CircuitBreaker.State("a.example.com"))
+
+	assert.Equal(t, []string{
+		"a.example.com closed->open",
+		"a.example.com open->half-open",
+		"a.example.com half-open->open",
+		"a.example.com open->half-open",
+		"a.example.com half-open->closed",
+	}, changes)
+}
+
+func TestCircuitBreaker_SuccessResetsFailures(t *testing.T) {
+	b := &CircuitBreaker{FailureThreshold: 2}
+	clock := newFakeClock()
+	send := func(status int) {
+		_, _ = b.do(context.Background(), clock, "host", func() (*http.Response, error) {
+			return &http.Response{StatusCode: status}, nil
+		})
+	}
+
+	send(http.StatusInternalServerError)
+	send(http.StatusOK)
+	send(http.StatusInternalServerError)
+	assert.Equal(t, CircuitClosed, b.State("host"))
+
+	send(http.StatusBadGateway)
+	assert.Equal(t, CircuitOpen, b.State("host"))
+}
+
+func TestCircuitBreaker_HalfOpen(t *testing.T) {
+	b := &CircuitBreaker{FailureThreshold: 1, CoolDown: time.Second, HalfOpenRequests: 2}
+	clock := newFakeClock()
+	fail := func() (*http.Response, error) { return nil, errors.New("connection refused") }
+
+	_, err := b.do(context.Background(), clock, "host", fail)
+	assert.EqualError(t, err, "connection refused")
+	assert.Equal(t, CircuitOpen, b.State("host"))
+	clock.Advance(time.Second)
+
+	// two trials may be in flight at once, but not a third
+	first, err := b.allow(clock, "host")
+	assert.NoError(t, err)
+	second, err := b.allow(clock, "host")
+	assert.NoError(t, err)
+	_, err = b.allow(clock, "host")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// a cancelled trial gives back its slot without counting
+	b.record(clock, "host", first, true, true)
+	assert.Equal(t, CircuitHalfOpen, b.State("host"))
+	third, err := b.allow(clock, "host")
+	assert.NoError(t, err)
+
+	b.record(clock, "host", second, false, false)
+	assert.Equal(t, CircuitHalfOpen, b.State("host"))
+	b.record(clock, "host", third, false, false)
+	assert.Equal(t, CircuitClosed, b.State("host"))
+
+	// a late result from before the circuit closed is ignored
+	b.record(clock, "host", first, false, true)
+	assert.Equal(t, CircuitClosed, b.State("host"))
+}
+
+func TestTools_CircuitBreaker_Retry(t *testing.T) {
+	client, calls := statusSequence(503)
+	testTools := Tools{
+		HTTPClient:     client,
+		Clock:          newFakeClock(),
+		Retry:          &RetryPolicy{MaxAttempts: 5},
+		CircuitBreaker: &CircuitBreaker{FailureThreshold: 2},
+	}
+
+	_, err := testTools.GetJSON(context.Background(), "http://example.com", nil)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, calls())
+}
+
+func TestCircuitState_String(t *testing.T) {
+	assert.Equal(t, "half-open", CircuitHalfOpen.String())
+	assert.Equal(t, "CircuitState(7)", CircuitState(7).String())
+}
@@ -0,0 +1,229 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorSnippet is the most of an error response body kept in an HTTPError.
+const maxErrorSnippet = 512
+
+// RequestOption configures a request made by DoJSON and the methods built on it.
+type RequestOption func(*requestOptions)
+
+type requestOptions struct {
+	header            http.Header
+	client            *http.Client
+	errorBody         any
+	newIdempotencyKey bool
+}
+
+// WithHeader sets a request header.
+func WithHeader(key, value string) RequestOption {
+	return func(o *requestOptions) { o.header.Set(key, value) }
+}
+
+// WithHeaders sets every header in h on the request.
+func WithHeaders(h http.Header) RequestOption {
+	return func(o *requestOptions) {
+		for key, values := range h {
+			o.header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+}
+
+// WithHTTPClient sends the request with client instead of Tools.HTTPClient.
+func WithHTTPClient(client *http.Client) RequestOption {
+	return func(o *requestOptions) { o.client = client }
+}
+
+// WithErrorBody decodes the body of an unsuccessful response into dst, which should be a pointer
+// to the type the remote service uses for its errors. The HTTPError is still returned.
+func WithErrorBody(dst any) RequestOption {
+	return func(o *requestOptions) { o.errorBody = dst }
+}
+
+// HTTPError is returned by DoJSON when the remote service answers with a status other than 2xx.
+// Snippet holds the start of the response body, to help diagnose the failure.
+type HTTPError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Snippet    string
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Snippet != "" {
+		msg += ": " + e.Snippet
+	}
+
+	return msg
+}
+
+// DoJSON sends a request with the given method to uri and decodes the JSON response. body, if not
+// nil, is encoded as the JSON request body. A 2xx response body is decoded into result, which may be
+// nil to discard it; any other status results in an *HTTPError, with the body decoded into the
+// value given to WithErrorBody, if any. Response bodies may be at most MaxJSONSize bytes.
+//
+// The returned response's body has already been read and closed, but is replaced with a copy that
+// callers can still read. The response is returned along with an *HTTPError or decoding error,
+// but is nil if the request could not be sent.
+func (t *Tools) DoJSON(ctx context.Context, method, uri string, body, result any, opts ...RequestOption) (*http.Response, error) {
+	o := &requestOptions{header: make(http.Header)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.header.Set("Accept", "application/json")
+
+	response, err := t.sendJSON(ctx, method, uri, body, o)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	maxBytes := t.maxJSONBytes()
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxBytes+1))
+	if err != nil {
+		return response, fmt.Errorf("error reading response body: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return response, fmt.Errorf("response body is larger than %d bytes", maxBytes)
+	}
+	response.Body = io.NopCloser(bytes.NewReader(data))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		if o.errorBody != nil && len(bytes.TrimSpace(data)) > 0 {
+			_ = json.Unmarshal(data, o.errorBody)
+		}
+		return response, &HTTPError{Method: method, URL: uri, StatusCode: response.StatusCode, Snippet: bodySnippet(data)}
+	}
+
+	if result != nil && len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, result); err != nil {
+			return response, fmt.Errorf("error decoding response body: %w", err)
+		}
+	}
+
+	return response, nil
+}
+
+// GetJSON sends a GET request to uri and decodes the JSON response into result, like DoJSON.
+func (t *Tools) GetJSON(ctx context.Context, uri string, result any, opts ...RequestOption) (*http.Response, error) {
+	return t.DoJSON(ctx, http.MethodGet, uri, nil, result, opts...)
+}
+
+// PostJSON sends body to uri with POST and decodes the JSON response into result, like DoJSON.
+func (t *Tools) PostJSON(ctx context.Context, uri string, body, result any, opts ...RequestOption) (*http.Response, error) {
+	return t.DoJSON(ctx, http.MethodPost, uri, body, result, opts...)
+}
+
+// PutJSON sends body to uri with PUT and decodes the JSON response into result, like DoJSON.
+func (t *Tools) PutJSON(ctx context.Context, uri string, body, result any, opts ...RequestOption) (*http.Response, error) {
+	return t.DoJSON(ctx, http.MethodPut, uri, body, result, opts...)
+}
+
+// PatchJSON sends body to uri with PATCH and decodes the JSON response into result, like DoJSON.
+func (t *Tools) PatchJSON(ctx context.Context, uri string, body, result any, opts ...RequestOption) (*http.Response, error) {
+	return t.DoJSON(ctx, http.MethodPatch, uri, body, result, opts...)
+}
+
+// DeleteJSON sends a DELETE request to uri and decodes the JSON response into result, like DoJSON.
+func (t *Tools) DeleteJSON(ctx context.Context, uri string, result any, opts ...RequestOption) (*http.Response, error) {
+	return t.DoJSON(ctx, http.MethodDelete, uri, nil, result, opts...)
+}
+
+// DoJSONAs is the generic counterpart of DoJSON, returning the decoded response as a new value of type T.
+func DoJSONAs[T any](ctx context.Context, t *Tools, method, uri string, body any, opts ...RequestOption) (T, *http.Response, error) {
+	var result T
+	response, err := t.DoJSON(ctx, method, uri, body, &result, opts...)
+	if err != nil {
+		var zero T
+		return zero, response, err
+	}
+
+	return result, response, nil
+}
+
+// sendJSON encodes body, if not nil, and sends the request through CircuitBreaker, retrying it
+// according to Retry. A json.RawMessage body is sent exactly as it is.
+// It is the single path for outbound requests, used by DoJSON and PushJSONToRemote. The caller
+// must close the response body.
+func (t *Tools) sendJSON(ctx context.Context, method, uri string, body any, o *requestOptions) (*http.Response, error) {
+	var payload []byte
+	switch body := body.(type) {
+	case nil:
+	case json.RawMessage:
+		payload = body
+	default:
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range o.header {
+		request.Header[key] = values
+	}
+	if o.newIdempotencyKey && request.Header.Get(idempotencyKeyHeader) == "" {
+		request.Header.Set(idempotencyKeyHeader, randomID(32))
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	// every attempt needs its own request, with the body rewound
+	client, clock := t.httpClient(o.client), t.clock()
+	send := func() (*http.Response, error) {
+		reqBody, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		attempt := request.Clone(ctx)
+		attempt.Body = reqBody
+
+		if t.CircuitBreaker == nil {
+			return client.Do(attempt)
+		}
+		return t.CircuitBreaker.do(ctx, clock, request.URL.Host, func() (*http.Response, error) {
+			return client.Do(attempt)
+		})
+	}
+
+	if t.Retry == nil {
+		return send()
+	}
+
+	return t.Retry.do(ctx, clock, method, uri, request.Header, send)
+}
+
+// httpClient returns client, or HTTPClient, or a new default client.
+func (t *Tools) httpClient(client *http.Client) *http.Client {
+	switch {
+	case client != nil:
+		return client
+	case t.HTTPClient != nil:
+		return t.HTTPClient
+	default:
+		return &http.Client{}
+	}
+}
+
+// bodySnippet returns the start of a response body, on a single line.
+func bodySnippet(data []byte) string {
+	s := strings.Join(strings.Fields(string(data)), " ")
+	if len(s) > maxErrorSnippet {
+		s = strings.ToValidUTF8(s[:maxErrorSnippet], "") + "..."
+	}
+
+	return s
+}
@@ -0,0 +1,136 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type clientItem struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type clientAPIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var doJSONTests = []struct {
+	name         string
+	method       string
+	body         any
+	status       int
+	response     string
+	expectedBody string
+	errorType    string
+}{
+	{name: "get", method: http.MethodGet, status: http.StatusOK, response: `{"id":1,"name":"a"}`},
+	{name: "put", method: http.MethodPut, body: clientItem{ID: 1, Name: "a"}, status: http.StatusOK, response: `{"id":1,"name":"a"}`, expectedBody: `{"id":1,"name":"a"}`},
+	{name: "patch", method: http.MethodPatch, body: map[string]string{"name": "a"}, status: http.StatusOK, response: `{"id":1,"name":"a"}`, expectedBody: `{"name":"a"}`},
+	{name: "delete no content", method: http.MethodDelete, status: http.StatusNoContent, response: ``},
+	{name: "not found", method: http.MethodGet, status: http.StatusNotFound, response: `{"code":"not_found","message":"no such item"}`, errorType: "http"},
+	{name: "bad json", method: http.MethodGet, status: http.StatusOK, response: `{"id":`, errorType: "decode"},
+}
+
+func TestTools_DoJSON(t *testing.T) {
+	for _, e := range doJSONTests {
+		t.Run(e.name, func(t *testing.T) {
+			var testTools Tools
+			var sentBody string
+			testTools.HTTPClient = NewTestClient(func(req *http.Request) *http.Response {
+				assert.Equal(t, e.method, req.Method)
+				assert.Equal(t, "application/json", req.Header.Get("Accept"))
+				assert.Equal(t, "token", req.Header.Get("Authorization"))
+				if req.Body != nil {
+					b, _ := io.ReadAll(req.Body)
+					sentBody = string(b)
+				}
+				return &http.Response{
+					StatusCode: e.status,
+					Body:       io.NopCloser(strings.NewReader(e.response)),
+					Header:     make(http.Header),
+				}
+			})
+
+			var item clientItem
+			var apiErr clientAPIError
+			resp, err := testTools.DoJSON(context.Background(), e.method, "http://example.com/items/1", e.body, &item,
+				WithHeader("Authorization", "token"), WithErrorBody(&apiErr))
+
+			assert.Equal(t, e.expectedBody, sentBody)
+			if assert.NotNil(t, resp) {
+				assert.Equal(t, e.status, resp.StatusCode)
+				// the body can still be read by the caller
+				b, _ := io.ReadAll(resp.Body)
+				assert.Equal(t, e.response, string(b))
+			}
+
+			switch e.errorType {
+			case "":
+				assert.NoError(t, err)
+				if e.response != "" {
+					assert.Equal(t, clientItem{ID: 1, Name: "a"}, item)
+				}
+			case "http":
+				var httpErr *HTTPError
+				if assert.ErrorAs(t, err, &httpErr) {
+					assert.Equal(t, e.status, httpErr.StatusCode)
+					assert.Equal(t, e.response, httpErr.Snippet)
+				}
+				assert.Equal(t, "not_found", apiErr.Code)
+			case "decode":
+				assert.ErrorContains(t, err, "error decoding response body")
+			}
+		})
+	}
+}
+
+type clientContextKey struct{}
+
+func TestTools_DoJSON_Context(t *testing.T) {
+	testTools := Tools{HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+		assert.Equal(t, "value", req.Context().Value(clientContextKey{}))
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(``)), Header: make(http.Header)}
+	})}
+
+	ctx := context.WithValue(context.Background(), clientContextKey{}, "value")
+	_, err := testTools.GetJSON(ctx, "http://example.com", nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	resp, err := testTools.GetJSON(ctx, "http://example.com", nil, WithHTTPClient(&http.Client{}))
+	assert.Nil(t, resp)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestTools_DoJSON_TooLarge(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 10, HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"name":"` + strings.Repeat("a", 20) + `"}`)), Header: make(http.Header)}
+	})}
+
+	_, err := testTools.GetJSON(context.Background(), "http://example.com", nil)
+	assert.ErrorContains(t, err, "larger than 10 bytes")
+}
+
+func TestDoJSONAs(t *testing.T) {
+	testTools := Tools{HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+		return &http.Response{StatusCode: http.StatusCreated, Body: io.NopCloser(strings.NewReader(`{"id":7}`)), Header: make(http.Header)}
+	})}
+
+	item, resp, err := DoJSONAs[clientItem](context.Background(), &testTools, http.MethodPost, "http://example.com", clientItem{Name: "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, 7, item.ID)
+}
+
+func TestBodySnippet(t *testing.T) {
+	assert.Equal(t, "a b", bodySnippet([]byte("a\n  b\n")))
+	assert.Len(t, bodySnippet([]byte(strings.Repeat("x", 1000))), maxErrorSnippet+3)
+}
@@ -0,0 +1,375 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Codec encodes and decodes request and response bodies in one media type.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                { return "application/json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string                { return "application/xml" }
+func (xmlCodec) Marshal(v any) ([]byte, error)      { return xml.Marshal(v) }
+func (xmlCodec) Unmarshal(data []byte, v any) error { return xml.Unmarshal(data, v) }
+
+type yamlCodec struct{}
+
+func (yamlCodec) ContentType() string                { return "application/yaml" }
+func (yamlCodec) Marshal(v any) ([]byte, error)      { return yaml.Marshal(v) }
+func (yamlCodec) Unmarshal(data []byte, v any) error { return yaml.Unmarshal(data, v) }
+
+// msgpackCodec encodes MessagePack using the json struct tags, so that field names and
+// omitempty match the JSON representation.
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+
+	return dec.Decode(v)
+}
+
+// cborCodec encodes CBOR. Struct fields without a cbor tag use their json tag.
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string                { return "application/cbor" }
+func (cborCodec) Marshal(v any) ([]byte, error)      { return cbor.Marshal(v) }
+func (cborCodec) Unmarshal(data []byte, v any) error { return cbor.Unmarshal(data, v) }
+
+// The built-in codecs. Other formats can be added to a CodecRegistry by implementing Codec.
+var (
+	JSONCodec    Codec = jsonCodec{}
+	XMLCodec     Codec = xmlCodec{}
+	YAMLCodec    Codec = yamlCodec{}
+	MsgPackCodec Codec = msgpackCodec{}
+	CBORCodec    Codec = cborCodec{}
+)
+
+// CodecRegistry maps media types to codecs for Tools.Write and Tools.Read.
+// The first codec registered is the default, used when the client expresses no preference.
+type CodecRegistry struct {
+	mu     sync.RWMutex
+	order  []string
+	codecs map[string]Codec
+}
+
+// NewCodecRegistry returns a registry holding codecs, registered under their own content types.
+func NewCodecRegistry(codecs ...Codec) *CodecRegistry {
+	reg := &CodecRegistry{codecs: make(map[string]Codec)}
+	for _, c := range codecs {
+		reg.Register(c)
+	}
+
+	return reg
+}
+
+// DefaultCodecs returns a registry with the JSON (the default), XML, YAML, MessagePack and CBOR
+// codecs, including the common alternative media types for XML, YAML and MessagePack.
+func DefaultCodecs() *CodecRegistry {
+	reg := NewCodecRegistry(JSONCodec)
+	reg.Register(XMLCodec, "text/xml")
+	reg.Register(YAMLCodec, "application/x-yaml", "text/yaml")
+	reg.Register(MsgPackCodec, "application/x-msgpack", "application/vnd.msgpack")
+	reg.Register(CBORCodec)
+
+	return reg
+}
+
+// Register adds c under its content type and any aliases, replacing codecs registered under the same types.
+func (reg *CodecRegistry) Register(c Codec, aliases ...string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, mediaType := range append([]string{c.ContentType()}, aliases...) {
+		mediaType = strings.ToLower(mediaType)
+		if _, ok := reg.codecs[mediaType]; !ok {
+			reg.order = append(reg.order, mediaType)
+		}
+		reg.codecs[mediaType] = c
+	}
+}
+
+// Lookup returns the codec registered for mediaType, ignoring any parameters such as charset.
+func (reg *CodecRegistry) Lookup(mediaType string) (Codec, bool) {
+	if mt, _, err := mime.ParseMediaType(mediaType); err == nil {
+		mediaType = mt
+	}
+
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	c, ok := reg.codecs[strings.ToLower(mediaType)]
+	return c, ok
+}
+
+// Negotiate picks the codec that best matches an Accept header. Each media type is given the
+// quality of the most specific range that matches it, and the highest quality wins, with ties
+// going to the range listed first and then to the codec registered first. An empty header
+// selects the default codec.
+func (reg *CodecRegistry) Negotiate(accept string) (Codec, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	if len(reg.order) == 0 {
+		return nil, false
+	}
+	if strings.TrimSpace(accept) == "" {
+		return reg.codecs[reg.order[0]], true
+	}
+
+	ranges := parseAccept(accept)
+
+	var best acceptRange
+	bestType := ""
+	for _, mediaType := range reg.order {
+		ar, ok := bestRange(ranges, mediaType)
+		if !ok || ar.q == 0 {
+			continue
+		}
+		if bestType == "" || ar.q > best.q || (ar.q == best.q && ar.index < best.index) {
+			best, bestType = ar, mediaType
+		}
+	}
+
+	if bestType == "" {
+		return nil, false
+	}
+
+	return reg.codecs[bestType], true
+}
+
+// bestRange returns the most specific range in ranges that matches mediaType.
+func bestRange(ranges []acceptRange, mediaType string) (acceptRange, bool) {
+	var best acceptRange
+	found := false
+	for _, ar := range ranges {
+		if ar.matches(mediaType) && (!found || ar.specificity() > best.specificity()) {
+			best, found = ar, true
+		}
+	}
+
+	return best, found
+}
+
+// acceptRange is a single media range from an Accept header.
+type acceptRange struct {
+	mediaType string
+	q         float64
+	index     int
+}
+
+// specificity ranks "type/subtype" above "type/*" above "*/*".
+func (ar acceptRange) specificity() int {
+	switch {
+	case ar.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(ar.mediaType, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (ar acceptRange) matches(mediaType string) bool {
+	switch ar.specificity() {
+	case 0:
+		return true
+	case 1:
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(ar.mediaType, "*"))
+	default:
+		return ar.mediaType == mediaType
+	}
+}
+
+// parseAccept parses an Accept header into media ranges, in the order they were listed.
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+
+	for i, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if mediaType == "" {
+			continue
+		}
+
+		ar := acceptRange{mediaType: mediaType, q: 1, index: i}
+		for _, param := range fields[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(k), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && q >= 0 && q <= 1 {
+					ar.q = q
+				}
+			}
+		}
+		ranges = append(ranges, ar)
+	}
+
+	return ranges
+}
+
+// codecs returns the registry configured on t, or the default one.
+func (t *Tools) codecs() *CodecRegistry {
+	if t.Codecs != nil {
+		return t.Codecs
+	}
+
+	return defaultCodecs
+}
+
+// defaultCodecs is shared by every Tools value without its own registry.
+var defaultCodecs = DefaultCodecs()
+
+// Write is like WriteJSON, but encodes data in the format requested by the Accept header of r,
+// choosing from the codecs in t.Codecs (JSON, XML, YAML, MessagePack and CBOR by default). If the
+// client accepts none of them, nothing is written and a *NotAcceptableError is returned, which
+// ErrorJSON reports as 406 Not Acceptable.
+//
+// Fields are pruned and Envelope is applied as WriteJSON does. Pruned data is encoded as the
+// generic maps and slices its JSON decodes to, which XMLCodec cannot encode.
+func (t *Tools) Write(w http.ResponseWriter, r *http.Request, status int, data any, headers ...http.Header) error {
+	accept := r.Header.Get("Accept")
+
+	c, ok := t.codecs().Negotiate(accept)
+	if !ok {
+		return &NotAcceptableError{Accept: accept}
+	}
+
+	w = t.WithRequest(w, r)
+
+	data, err := t.responseData(w, status, data)
+	if err != nil {
+		return err
+	}
+	if raw, ok := data.(json.RawMessage); ok && c.ContentType() != JSONCodec.ContentType() {
+		var generic any
+		if err := json.Unmarshal(raw, &generic); err != nil {
+			return err
+		}
+		data = generic
+	}
+	if err := t.setETag(w, status, data, c.Marshal); err != nil {
+		return err
+	}
+
+	out, err := c.Marshal(t.envelope().Success(status, data, envelopeMeta(w)))
+	if err != nil {
+		return err
+	}
+
+	w.Header().Add("Vary", "Accept")
+
+	return t.writeBody(w, status, out, c.ContentType(), headers...)
+}
+
+// Read is like ReadJSON, but decodes the body with the codec matching the Content-Type header of r.
+// A request without a Content-Type is read as JSON. MaxJSONSize applies whatever the format; JSON
+// bodies get all of ReadJSON's checks, and ValidateJSON applies to every format. An unknown
+// Content-Type results in an *UnsupportedMediaTypeError.
+func (t *Tools) Read(w http.ResponseWriter, r *http.Request, data any) error {
+	contentType := r.Header.Get("Content-Type")
+
+	c := JSONCodec
+	if contentType != "" {
+		var ok bool
+		if c, ok = t.codecs().Lookup(contentType); !ok {
+			return &UnsupportedMediaTypeError{ContentType: contentType}
+		}
+	}
+
+	body, err := t.readJSONBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c.(jsonCodec); ok {
+		return t.decodeJSON(body, data)
+	}
+
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return ErrEmptyBody
+	}
+
+	if err := c.Unmarshal(body, data); err != nil {
+		return &BodyDecodeError{ContentType: c.ContentType(), Err: err}
+	}
+
+	if t.ValidateJSON {
+		return t.Validate(data)
+	}
+
+	return nil
+}
+
+// NotAcceptableError is returned by Write when no registered codec satisfies the Accept header.
+type NotAcceptableError struct {
+	Accept string
+}
+
+func (e *NotAcceptableError) Error() string {
+	return fmt.Sprintf("none of the available response formats is acceptable (Accept: %s)", e.Accept)
+}
+
+func (e *NotAcceptableError) Unwrap() error   { return ErrNotAcceptable }
+func (e *NotAcceptableError) HTTPStatus() int { return http.StatusNotAcceptable }
+
+// UnsupportedMediaTypeError is returned by Read when no codec is registered for the Content-Type.
+type UnsupportedMediaTypeError struct {
+	ContentType string
+}
+
+func (e *UnsupportedMediaTypeError) Error() string {
+	return fmt.Sprintf("unsupported content type %q", e.ContentType)
+}
+
+func (e *UnsupportedMediaTypeError) Unwrap() error   { return ErrUnsupportedMediaType }
+func (e *UnsupportedMediaTypeError) HTTPStatus() int { return http.StatusUnsupportedMediaType }
+
+// BodyDecodeError is returned by Read when a non-JSON body cannot be decoded.
+type BodyDecodeError struct {
+	ContentType string
+	Err         error
+}
+
+func (e *BodyDecodeError) Error() string {
+	return fmt.Sprintf("body contains badly-formed %s: %s", e.ContentType, e.Err)
+}
+
+// Unwrap returns both ErrBadBody and the codec's own error.
+func (e *BodyDecodeError) Unwrap() []error { return []error{ErrBadBody, e.Err} }
+func (e *BodyDecodeError) HTTPStatus() int { return http.StatusBadRequest }
@@ -0,0 +1,192 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type codecPayload struct {
+	Name  string `json:"name" xml:"name" yaml:"name"`
+	Count int    `json:"count" xml:"count" yaml:"count"`
+}
+
+type upperCodec struct{}
+
+func (upperCodec) ContentType() string { return "text/x-upper" }
+func (upperCodec) Marshal(v any) ([]byte, error) {
+	out, err := json.Marshal(v)
+	return bytes.ToUpper(out), err
+}
+func (upperCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(bytes.ToLower(data), v)
+}
+
+var negotiateTests = []struct {
+	name         string
+	accept       string
+	expectedType string
+}{
+	{name: "no accept header", accept: "", expectedType: "application/json"},
+	{name: "wildcard", accept: "*/*", expectedType: "application/json"},
+	{name: "exact xml", accept: "application/xml", expectedType: "application/xml"},
+	{name: "text xml alias", accept: "text/xml", expectedType: "application/xml"},
+	{name: "quality values", accept: "application/json;q=0.5, application/yaml;q=0.9", expectedType: "application/yaml"},
+	{name: "listed order breaks ties", accept: "application/xml, application/json", expectedType: "application/xml"},
+	{name: "specific range wins over wildcard", accept: "*/*;q=0.1, application/json;q=0", expectedType: "application/xml"},
+	{name: "type wildcard", accept: "text/*", expectedType: "application/xml"},
+	{name: "msgpack", accept: "application/msgpack", expectedType: "application/msgpack"},
+	{name: "msgpack alias", accept: "application/x-msgpack", expectedType: "application/msgpack"},
+	{name: "cbor", accept: "application/cbor", expectedType: "application/cbor"},
+	{name: "not acceptable", accept: "image/png", expectedType: ""},
+}
+
+func TestTools_Write(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range negotiateTests {
+		t.Run(e.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			if e.accept != "" {
+				req.Header.Set("Accept", e.accept)
+			}
+
+			rr := httptest.NewRecorder()
+			err := testTools.Write(rr, req, http.StatusOK, codecPayload{Name: "foo", Count: 2})
+
+			if e.expectedType == "" {
+				assert.ErrorIs(t, err, ErrNotAcceptable)
+				assert.Equal(t, http.StatusNotAcceptable, statusFromError(err, 0))
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, e.expectedType, rr.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", rr.Header().Get("Vary"))
+			assert.Contains(t, rr.Body.String(), "foo")
+		})
+	}
+}
+
+var readTests = []struct {
+	name          string
+	contentType   string
+	body          string
+	errorExpected error
+}{
+	{name: "no content type", contentType: "", body: `{"name":"foo","count":2}`},
+	{name: "json with charset", contentType: "application/json; charset=utf-8", body: `{"name":"foo","count":2}`},
+	{name: "xml", contentType: "application/xml", body: `<codecPayload><name>foo</name><count>2</count></codecPayload>`},
+	{name: "yaml", contentType: "application/yaml", body: "name: foo\ncount: 2\n"},
+	{name: "bad xml", contentType: "text/xml", body: `<codecPayload><name>foo</name>`, errorExpected: ErrBadBody},
+	{name: "empty yaml", contentType: "application/yaml", body: ``, errorExpected: ErrEmptyBody},
+	{name: "json errors are unchanged", contentType: "application/json", body: `{"name":"foo","bar":1}`, errorExpected: ErrUnknownField},
+	{name: "unsupported", contentType: "text/csv", body: `name,count`, errorExpected: ErrUnsupportedMediaType},
+	{name: "too large", contentType: "application/yaml", body: "name: " + strings.Repeat("x", 100), errorExpected: ErrBodyTooLarge},
+}
+
+func TestTools_Read(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 64}
+
+	for _, e := range readTests {
+		t.Run(e.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/", strings.NewReader(e.body))
+			if e.contentType != "" {
+				req.Header.Set("Content-Type", e.contentType)
+			}
+
+			var payload codecPayload
+			err := testTools.Read(httptest.NewRecorder(), req, &payload)
+
+			if e.errorExpected != nil {
+				assert.ErrorIs(t, err, e.errorExpected)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, codecPayload{Name: "foo", Count: 2}, payload)
+		})
+	}
+}
+
+func TestTools_CustomCodec(t *testing.T) {
+	reg := DefaultCodecs()
+	reg.Register(upperCodec{})
+	testTools := Tools{Codecs: reg}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "text/x-upper")
+
+	rr := httptest.NewRecorder()
+	err := testTools.Write(rr, req, http.StatusOK, codecPayload{Name: "foo", Count: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "text/x-upper", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `{"NAME":"FOO","COUNT":2}`, rr.Body.String())
+
+	req, _ = http.NewRequest("POST", "/", strings.NewReader(rr.Body.String()))
+	req.Header.Set("Content-Type", "text/x-upper")
+
+	var payload codecPayload
+	err = testTools.Read(httptest.NewRecorder(), req, &payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", payload.Name)
+}
+
+func TestTools_BinaryCodecs(t *testing.T) {
+	var testTools Tools
+
+	for _, contentType := range []string{"application/msgpack", "application/cbor"} {
+		t.Run(contentType, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept", contentType)
+
+			rr := httptest.NewRecorder()
+			err := testTools.Write(rr, req, http.StatusOK, codecPayload{Name: "foo", Count: 2})
+			assert.NoError(t, err)
+			assert.Equal(t, contentType, rr.Header().Get("Content-Type"))
+
+			// field names follow the json tags
+			var generic map[string]any
+			c, _ := testTools.codecs().Lookup(contentType)
+			assert.NoError(t, c.Unmarshal(rr.Body.Bytes(), &generic))
+			assert.Contains(t, generic, "name")
+
+			req, _ = http.NewRequest("POST", "/", bytes.NewReader(rr.Body.Bytes()))
+			req.Header.Set("Content-Type", contentType)
+
+			var payload codecPayload
+			err = testTools.Read(httptest.NewRecorder(), req, &payload)
+			assert.NoError(t, err)
+			assert.Equal(t, codecPayload{Name: "foo", Count: 2}, payload)
+		})
+	}
+}
+
+func TestTools_Write_FieldsAndEnvelope(t *testing.T) {
+	testTools := Tools{SparseFields: true, Envelope: StandardEnvelope}
+
+	for _, contentType := range []string{"application/json", "application/yaml", "application/msgpack"} {
+		t.Run(contentType, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/?fields=name", nil)
+			req.Header.Set("Accept", contentType)
+
+			rr := httptest.NewRecorder()
+			err := testTools.Write(rr, req, http.StatusOK, codecPayload{Name: "foo", Count: 2})
+			assert.NoError(t, err)
+
+			var body struct {
+				Success bool           `json:"success" yaml:"success"`
+				Data    map[string]any `json:"data" yaml:"data"`
+			}
+			c, _ := testTools.codecs().Lookup(contentType)
+			assert.NoError(t, c.Unmarshal(rr.Body.Bytes(), &body))
+			assert.True(t, body.Success)
+			assert.Equal(t, map[string]any{"name": "foo"}, body.Data)
+		})
+	}
+}
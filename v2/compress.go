@@ -0,0 +1,279 @@
+package toolkit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultCompressMinSize is the smallest response body compressed when CompressMinSize is not set.
+const defaultCompressMinSize = 1024
+
+// ContentEncoding is an HTTP content coding, such as gzip, used to compress responses and
+// decompress request bodies.
+type ContentEncoding interface {
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+type gzipEncoding struct{}
+
+func (gzipEncoding) Name() string                                  { return "gzip" }
+func (gzipEncoding) NewWriter(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }
+func (gzipEncoding) NewReader(r io.Reader) (io.ReadCloser, error)  { return gzip.NewReader(r) }
+
+// deflateEncoding implements HTTP's "deflate" coding, which is the zlib format.
+type deflateEncoding struct{}
+
+func (deflateEncoding) Name() string                                  { return "deflate" }
+func (deflateEncoding) NewWriter(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil }
+func (deflateEncoding) NewReader(r io.Reader) (io.ReadCloser, error)  { return zlib.NewReader(r) }
+
+// zstdEncoding implements the "zstd" coding of RFC 8878. Each body gets its own single-threaded
+// encoder or decoder, as bodies are small and compressed per request.
+type zstdEncoding struct{}
+
+// boundedDecoder is implemented by encodings whose decoders allocate according to what the
+// compressed data asks for, such as zstd's window, so that they can be held to the size of the
+// largest body that will be read.
+type boundedDecoder interface {
+	newBoundedReader(r io.Reader, limit int64) (io.ReadCloser, error)
+}
+
+func (zstdEncoding) Name() string { return "zstd" }
+
+func (zstdEncoding) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+}
+
+func (zstdEncoding) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+
+	return zr.IOReadCloser(), nil
+}
+
+// newBoundedReader returns a decoder that refuses frames whose window, and so the memory it
+// would allocate, is larger than limit rounded up to a power of two.
+func (zstdEncoding) newBoundedReader(r io.Reader, limit int64) (io.ReadCloser, error) {
+	size := uint64(zstd.MinWindowSize)
+	if uint64(limit) > size {
+		size = 1 << bits.Len64(uint64(limit)-1)
+	}
+
+	zr, err := zstd.NewReader(r,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxMemory(size),
+		zstd.WithDecoderMaxWindow(min(size, zstd.MaxWindowSize)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return zr.IOReadCloser(), nil
+}
+
+// The built-in content encodings. Others, such as br, can be used by implementing
+// ContentEncoding on top of the library of your choice and listing them in Tools.ContentEncodings.
+var (
+	GzipEncoding    ContentEncoding = gzipEncoding{}
+	DeflateEncoding ContentEncoding = deflateEncoding{}
+	ZstdEncoding    ContentEncoding = zstdEncoding{}
+)
+
+// contentEncodings returns the encodings configured on t, or gzip, deflate and zstd.
+func (t *Tools) contentEncodings() []ContentEncoding {
+	if len(t.ContentEncodings) > 0 {
+		return t.ContentEncodings
+	}
+
+	return []ContentEncoding{GzipEncoding, DeflateEncoding, ZstdEncoding}
+}
+
+// negotiateEncoding picks the encoding to use for a response from an Accept-Encoding header.
+// The highest quality wins, with ties going to the encoding listed first in Tools.ContentEncodings.
+// It returns nil if the client accepts none of them, and identity should be used.
+func (t *Tools) negotiateEncoding(acceptEncoding string) ContentEncoding {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return nil
+	}
+
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(k), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && f >= 0 && f <= 1 {
+					q = f
+				}
+			}
+		}
+		qualities[name] = q
+	}
+
+	var best ContentEncoding
+	bestQ := 0.0
+	for _, enc := range t.contentEncodings() {
+		q, ok := qualities[strings.ToLower(enc.Name())]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+
+	return best
+}
+
+// compressBody compresses out for the request carried by w, if Compress is set, the body is at
+// least CompressMinSize bytes and the client accepts one of the content encodings. It sets the
+// Content-Encoding and Vary headers when it does, and returns out unchanged when it does not.
+func (t *Tools) compressBody(w http.ResponseWriter, out []byte) ([]byte, error) {
+	r := requestOf(w)
+	if !t.Compress || r == nil {
+		return out, nil
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	minSize := defaultCompressMinSize
+	if t.CompressMinSize > 0 {
+		minSize = t.CompressMinSize
+	}
+	if len(out) < minSize || w.Header().Get("Content-Encoding") != "" {
+		return out, nil
+	}
+
+	enc := t.negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if enc == nil {
+		return out, nil
+	}
+
+	var buf bytes.Buffer
+	zw, err := enc.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(out); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Encoding", enc.Name())
+	w.Header().Del("Content-Length")
+	if etag := w.Header().Get("ETag"); etag != "" {
+		w.Header().Set("ETag", encodedETag(etag, enc.Name()))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompressedBody returns a reader for the body of r with any Content-Encoding removed. Decoders
+// that support it are bounded by limit, the largest body that will be read. Closing the reader
+// releases the decoders; it does not close r.Body.
+func (t *Tools) decompressedBody(r *http.Request, limit int64) (*decodedBody, error) {
+	body := &decodedBody{Reader: r.Body}
+
+	header := strings.TrimSpace(r.Header.Get("Content-Encoding"))
+	if header == "" {
+		return body, nil
+	}
+
+	// codings are listed in the order they were applied, so undo them in reverse
+	codings := strings.Split(header, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		name := strings.ToLower(strings.TrimSpace(codings[i]))
+		if name == "identity" || name == "" {
+			continue
+		}
+
+		var enc ContentEncoding
+		for _, e := range t.contentEncodings() {
+			if strings.EqualFold(e.Name(), name) {
+				enc = e
+				break
+			}
+		}
+		if enc == nil {
+			body.Close()
+			return nil, &UnsupportedEncodingError{Encoding: name}
+		}
+
+		var zr io.ReadCloser
+		var err error
+		if bd, ok := enc.(boundedDecoder); ok {
+			zr, err = bd.newBoundedReader(body.Reader, limit)
+		} else {
+			zr, err = enc.NewReader(body.Reader)
+		}
+		if err != nil {
+			body.Close()
+			return nil, &BodyDecodeError{ContentType: name, Err: err}
+		}
+		body.Reader = zr
+		body.decoders = append(body.decoders, zr)
+	}
+
+	return body, nil
+}
+
+// decodedBody is a request body read through the decoders of its content codings.
+type decodedBody struct {
+	io.Reader
+	decoders []io.Closer
+}
+
+// decoded reports whether the body is read through any decoders.
+func (b *decodedBody) decoded() bool { return len(b.decoders) > 0 }
+
+// Close closes the decoders, starting with the one the body is read from.
+func (b *decodedBody) Close() error {
+	var errs []error
+	for i := len(b.decoders) - 1; i >= 0; i-- {
+		errs = append(errs, b.decoders[i].Close())
+	}
+	b.decoders = nil
+
+	return errors.Join(errs...)
+}
+
+// decoderLimitError reports whether err is a decoder refusing data that would need more memory
+// than the limit it was given.
+func decoderLimitError(err error) bool {
+	return errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded)
+}
+
+// UnsupportedEncodingError is returned when a request body uses a Content-Encoding that is not
+// one of Tools.ContentEncodings.
+type UnsupportedEncodingError struct {
+	Encoding string
+}
+
+func (e *UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported content encoding %q", e.Encoding)
+}
+
+func (e *UnsupportedEncodingError) Unwrap() error   { return ErrUnsupportedEncoding }
+func (e *UnsupportedEncodingError) HTTPStatus() int { return http.StatusUnsupportedMediaType }
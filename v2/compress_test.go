@@ -0,0 +1,170 @@
+package toolkit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var compressTests = []struct {
+	name             string
+	acceptEncoding   string
+	size             int
+	expectedEncoding string
+}{
+	{name: "gzip", acceptEncoding: "gzip", size: 2000, expectedEncoding: "gzip"},
+	{name: "deflate", acceptEncoding: "deflate", size: 2000, expectedEncoding: "deflate"},
+	{name: "zstd", acceptEncoding: "zstd", size: 2000, expectedEncoding: "zstd"},
+	{name: "quality values", acceptEncoding: "gzip;q=0.5, deflate;q=0.8", size: 2000, expectedEncoding: "deflate"},
+	{name: "server preference breaks ties", acceptEncoding: "deflate, gzip", size: 2000, expectedEncoding: "gzip"},
+	{name: "wildcard", acceptEncoding: "*", size: 2000, expectedEncoding: "gzip"},
+	{name: "unsupported", acceptEncoding: "br", size: 2000, expectedEncoding: ""},
+	{name: "refused", acceptEncoding: "gzip;q=0", size: 2000, expectedEncoding: ""},
+	{name: "below threshold", acceptEncoding: "gzip", size: 10, expectedEncoding: ""},
+	{name: "no accept encoding", acceptEncoding: "", size: 2000, expectedEncoding: ""},
+}
+
+func TestTools_WriteJSONCompressed(t *testing.T) {
+	testTools := Tools{Compress: true}
+
+	for _, e := range compressTests {
+		t.Run(e.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			if e.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", e.acceptEncoding)
+			}
+
+			payload := JSONResponse{Message: strings.Repeat("a", e.size)}
+
+			rr := httptest.NewRecorder()
+			err := testTools.WriteJSON(testTools.WithRequest(rr, req), http.StatusOK, payload)
+			assert.NoError(t, err)
+			assert.Equal(t, e.expectedEncoding, rr.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+
+			var body io.Reader = rr.Body
+			switch e.expectedEncoding {
+			case "gzip":
+				body, err = gzip.NewReader(rr.Body)
+				assert.NoError(t, err)
+			case "deflate":
+				body, err = zlib.NewReader(rr.Body)
+				assert.NoError(t, err)
+			case "zstd":
+				body, err = ZstdEncoding.NewReader(rr.Body)
+				assert.NoError(t, err)
+			}
+
+			out, err := io.ReadAll(body)
+			assert.NoError(t, err)
+			assert.Contains(t, string(out), payload.Message)
+		})
+	}
+}
+
+func TestTools_WriteJSONNotCompressedWithoutRequest(t *testing.T) {
+	testTools := Tools{Compress: true}
+
+	rr := httptest.NewRecorder()
+	err := testTools.WriteJSON(rr, http.StatusOK, JSONResponse{Message: strings.Repeat("a", 2000)})
+	assert.NoError(t, err)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+}
+
+func TestTools_Middleware(t *testing.T) {
+	testTools := Tools{Compress: true, CompressMinSize: 10}
+
+	handler := testTools.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = testTools.WriteJSON(w, http.StatusOK, JSONResponse{Message: "hello, compressed world"})
+	}))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+}
+
+func gzipBytes(t *testing.T, s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(s))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+var decompressTests = []struct {
+	name            string
+	contentEncoding string
+	body            []byte
+	errorExpected   error
+}{
+	{name: "identity", contentEncoding: "", body: []byte(`{"foo":"bar"}`)},
+	{name: "gzip", contentEncoding: "gzip", body: nil},
+	{name: "zstd", contentEncoding: "zstd", body: nil},
+	{name: "corrupt gzip", contentEncoding: "gzip", body: []byte(`{"foo":"bar"}`), errorExpected: ErrBadBody},
+	{name: "unsupported", contentEncoding: "br", body: []byte(`{"foo":"bar"}`), errorExpected: ErrUnsupportedEncoding},
+	{name: "gzip bomb", contentEncoding: "gzip", body: []byte("bomb"), errorExpected: ErrBodyTooLarge},
+	{name: "zstd bomb", contentEncoding: "zstd", body: []byte("bomb"), errorExpected: ErrBodyTooLarge},
+}
+
+func TestTools_ReadJSONCompressed(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 4096}
+
+	for _, e := range decompressTests {
+		t.Run(e.name, func(t *testing.T) {
+			body := e.body
+			switch {
+			case e.name == "gzip":
+				body = gzipBytes(t, `{"foo":"bar"}`)
+			case e.name == "zstd":
+				var buf bytes.Buffer
+				zw, err := ZstdEncoding.NewWriter(&buf)
+				assert.NoError(t, err)
+				_, _ = zw.Write([]byte(`{"foo":"bar"}`))
+				assert.NoError(t, zw.Close())
+				body = buf.Bytes()
+			case e.name == "gzip bomb":
+				// compresses to far less than the limit, but expands to far more
+				body = gzipBytes(t, `{"foo":"`+strings.Repeat("a", 1024*1024)+`"}`)
+				assert.Less(t, len(body), int(testTools.maxJSONBytes()))
+			case e.name == "zstd bomb":
+				// asks for a window far larger than the limit before any data is decoded
+				var buf bytes.Buffer
+				zw, err := ZstdEncoding.NewWriter(&buf)
+				assert.NoError(t, err)
+				_, _ = zw.Write([]byte(`{"foo":"` + strings.Repeat("a", 1024*1024) + `"}`))
+				assert.NoError(t, zw.Close())
+				body = buf.Bytes()
+				assert.Less(t, len(body), int(testTools.maxJSONBytes()))
+			}
+
+			req, _ := http.NewRequest("POST", "/", bytes.NewReader(body))
+			if e.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", e.contentEncoding)
+			}
+
+			var decoded struct {
+				Foo string `json:"foo"`
+			}
+			err := testTools.ReadJSON(httptest.NewRecorder(), req, &decoded)
+
+			if e.errorExpected != nil {
+				assert.ErrorIs(t, err, e.errorExpected)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "bar", decoded.Foo)
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// defaultRequestIDHeader is the header used for request IDs when Tools.RequestIDHeader is not set.
+const defaultRequestIDHeader = "X-Request-ID"
+
+// Envelope shapes the payloads written by WriteJSON and ErrorJSON. Success wraps the data passed
+// to WriteJSON, and Error builds the body for an error passed to ErrorJSON. Both are given the
+// metadata of the response, which is only filled in when the ResponseWriter comes from WithRequest.
+type Envelope interface {
+	Success(status int, data any, meta EnvelopeMeta) any
+	Error(status int, err error, meta EnvelopeMeta) any
+}
+
+// EnvelopeMeta is the metadata available to an Envelope.
+type EnvelopeMeta struct {
+	RequestID string        // the request's ID, as returned by RequestID
+	Duration  time.Duration // the time since WithRequest was called for the request
+}
+
+// The built-in envelopes. DefaultEnvelope, used when Tools.Envelope is nil, writes data as it is
+// and errors as a JSONResponse. StandardEnvelope writes {success, data, errors, meta}, with the
+// request ID and timing in meta. BareEnvelope writes data as it is and errors as {"message": ...}.
+var (
+	DefaultEnvelope  Envelope = defaultEnvelope{}
+	StandardEnvelope Envelope = standardEnvelope{}
+	BareEnvelope     Envelope = bareEnvelope{}
+)
+
+type defaultEnvelope struct{}
+
+func (defaultEnvelope) Success(_ int, data any, _ EnvelopeMeta) any { return data }
+
+func (defaultEnvelope) Error(_ int, err error, _ EnvelopeMeta) any {
+	return JSONResponse{Error: true, Message: err.Error()}
+}
+
+type bareEnvelope struct{}
+
+func (bareEnvelope) Success(_ int, data any, _ EnvelopeMeta) any { return data }
+
+func (bareEnvelope) Error(_ int, err error, _ EnvelopeMeta) any {
+	return map[string]string{"message": err.Error()}
+}
+
+// StandardResponse is the payload written by StandardEnvelope.
+type StandardResponse struct {
+	Success bool            `json:"success"`
+	Data    any             `json:"data,omitempty"`
+	Errors  []EnvelopeError `json:"errors,omitempty"`
+	Meta    *ResponseMeta   `json:"meta,omitempty"`
+}
+
+// EnvelopeError is one entry in the errors of a StandardResponse. Validation and schema errors
+// give one entry per failing field, with the rule or keyword that failed as the code.
+type EnvelopeError struct {
+	Message string `json:"message"`
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+// ResponseMeta is the meta member of a StandardResponse.
+type ResponseMeta struct {
+	RequestID  string  `json:"request_id,omitempty"`
+	DurationMS float64 `json:"duration_ms,omitempty"`
+}
+
+type standardEnvelope struct{}
+
+func (standardEnvelope) Success(_ int, data any, meta EnvelopeMeta) any {
+	return StandardResponse{Success: true, Data: data, Meta: responseMeta(meta)}
+}
+
+func (standardEnvelope) Error(_ int, err error, meta EnvelopeMeta) any {
+	var errs []EnvelopeError
+
+	var verrs ValidationErrors
+	var serrs SchemaErrors
+	switch {
+	case errors.As(err, &verrs):
+		for _, fe := range verrs {
+			errs = append(errs, EnvelopeError{Message: fe.Message, Field: fe.Field, Code: fe.Rule})
+		}
+	case errors.As(err, &serrs):
+		for _, se := range serrs {
+			errs = append(errs, EnvelopeError{Message: se.Message, Field: se.InstancePath, Code: se.Keyword})
+		}
+	default:
+		errs = []EnvelopeError{{Message: err.Error()}}
+	}
+
+	return StandardResponse{Errors: errs, Meta: responseMeta(meta)}
+}
+
+// responseMeta converts meta for a StandardResponse, returning nil if there is none.
+func responseMeta(meta EnvelopeMeta) *ResponseMeta {
+	if meta == (EnvelopeMeta{}) {
+		return nil
+	}
+
+	return &ResponseMeta{RequestID: meta.RequestID, DurationMS: float64(meta.Duration.Microseconds()) / 1000}
+}
+
+// envelope returns the Envelope configured on t, or DefaultEnvelope.
+func (t *Tools) envelope() Envelope {
+	if t.Envelope != nil {
+		return t.Envelope
+	}
+
+	return DefaultEnvelope
+}
+
+// envelopeMeta returns the metadata of the response being written to w.
+func envelopeMeta(w http.ResponseWriter) EnvelopeMeta {
+	rw := requestWriterOf(w)
+	if rw == nil {
+		return EnvelopeMeta{}
+	}
+
+	return EnvelopeMeta{RequestID: rw.id, Duration: time.Since(rw.start)}
+}
+
+// requestIDHeader returns the header carrying request IDs.
+func (t *Tools) requestIDHeader() string {
+	if t.RequestIDHeader != "" {
+		return t.RequestIDHeader
+	}
+
+	return defaultRequestIDHeader
+}
+
+// requestID returns the ID of r, taken from its request ID header if that holds a reasonable
+// value, or a new random one.
+func (t *Tools) requestID(r *http.Request) string {
+	id := r.Header.Get(t.requestIDHeader())
+	if id == "" || len(id) > 128 {
+		return randomID(20)
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return randomID(20)
+		}
+	}
+
+	return id
+}
+
+// RequestID returns the ID of the request being answered through w, or "" if w did not come
+// from WithRequest. The ID is taken from the request's RequestIDHeader when it has one, and is
+// generated otherwise; it is also sent back in the same response header.
+func RequestID(w http.ResponseWriter) string {
+	if rw := requestWriterOf(w); rw != nil {
+		return rw.id
+	}
+
+	return ""
+}
@@ -0,0 +1,122 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var envelopeTests = []struct {
+	name            string
+	envelope        Envelope
+	expectedSuccess string
+	expectedError   string
+}{
+	{name: "default", envelope: nil, expectedSuccess: `{"id":1}`, expectedError: `{"error":true,"message":"boom"}`},
+	{name: "bare", envelope: BareEnvelope, expectedSuccess: `{"id":1}`, expectedError: `{"message":"boom"}`},
+	{name: "standard", envelope: StandardEnvelope, expectedSuccess: `{"success":true,"data":{"id":1}}`, expectedError: `{"success":false,"errors":[{"message":"boom"}]}`},
+}
+
+func TestTools_Envelope(t *testing.T) {
+	for _, e := range envelopeTests {
+		t.Run(e.name, func(t *testing.T) {
+			testTools := Tools{Envelope: e.envelope}
+
+			rr := httptest.NewRecorder()
+			assert.NoError(t, testTools.WriteJSON(rr, http.StatusOK, map[string]int{"id": 1}))
+			assert.Equal(t, e.expectedSuccess, rr.Body.String())
+
+			rr = httptest.NewRecorder()
+			assert.NoError(t, testTools.ErrorJSON(rr, errors.New("boom"), http.StatusServiceUnavailable))
+			assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+			assert.Equal(t, e.expectedError, rr.Body.String())
+		})
+	}
+}
+
+func TestTools_StandardEnvelope_Meta(t *testing.T) {
+	testTools := Tools{Envelope: StandardEnvelope}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	rr := httptest.NewRecorder()
+	w := testTools.WithRequest(rr, req)
+
+	assert.Equal(t, "abc-123", RequestID(w))
+	assert.NoError(t, testTools.WriteJSON(w, http.StatusOK, "ok"))
+	assert.Equal(t, "abc-123", rr.Header().Get("X-Request-ID"))
+
+	var resp StandardResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.True(t, resp.Success)
+	assert.Equal(t, "ok", resp.Data)
+	if assert.NotNil(t, resp.Meta) {
+		assert.Equal(t, "abc-123", resp.Meta.RequestID)
+	}
+}
+
+func TestTools_StandardEnvelope_ValidationErrors(t *testing.T) {
+	testTools := Tools{Envelope: StandardEnvelope}
+
+	err := ValidationErrors{{Field: "name", Rule: "required", Message: "name is required"}}
+	rr := httptest.NewRecorder()
+	assert.NoError(t, testTools.ErrorJSON(rr, err))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, `{"success":false,"errors":[{"message":"name is required","field":"name","code":"required"}]}`, rr.Body.String())
+}
+
+var requestIDTests = []struct {
+	name      string
+	header    string
+	generated bool
+}{
+	{name: "from header", header: "req-1"},
+	{name: "missing", header: "", generated: true},
+	{name: "control characters", header: "bad\tid", generated: true},
+}
+
+func TestTools_RequestID(t *testing.T) {
+	testTools := Tools{RequestIDHeader: "X-Correlation-ID"}
+
+	for _, e := range requestIDTests {
+		t.Run(e.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Correlation-ID", e.header)
+			rr := httptest.NewRecorder()
+
+			id := RequestID(testTools.WithRequest(rr, req))
+			if e.generated {
+				assert.Len(t, id, 20)
+			} else {
+				assert.Equal(t, e.header, id)
+			}
+			assert.Equal(t, id, rr.Header().Get("X-Correlation-ID"))
+		})
+	}
+
+	assert.Empty(t, RequestID(httptest.NewRecorder()))
+}
+
+func TestTools_WithRequest_NewRequest(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	w := testTools.WithRequest(rr, req)
+	id := RequestID(w)
+	start := requestWriterOf(w).start
+
+	req2 := req.WithContext(context.Background())
+	w2 := testTools.WithRequest(w, req2)
+
+	assert.Equal(t, id, RequestID(w2))
+	assert.Equal(t, id, rr.Header().Get(defaultRequestIDHeader))
+	assert.Equal(t, start, requestWriterOf(w2).start)
+	assert.Same(t, req2, requestOf(w2))
+}
@@ -0,0 +1,351 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HTTPStatusError is implemented by errors that know which HTTP status code describes them.
+// ErrorJSON uses it to pick the response status when none is given explicitly.
+type HTTPStatusError interface {
+	error
+	HTTPStatus() int
+}
+
+// statusError is a sentinel error with a fixed message and HTTP status code.
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string   { return e.msg }
+func (e *statusError) HTTPStatus() int { return e.status }
+
+// Sentinel errors returned by this package. They can be matched with errors.Is, including
+// through the more detailed error types below, which wrap them.
+var (
+	ErrEmptyBody             error = &statusError{http.StatusBadRequest, "body must not be empty"}
+	ErrMultipleJSONValues    error = &statusError{http.StatusBadRequest, "body must have only a single JSON value"}
+	ErrBodyTooLarge          error = &statusError{http.StatusRequestEntityTooLarge, "body is too large"}
+	ErrBadJSON               error = &statusError{http.StatusBadRequest, "body contains badly-formed JSON"}
+	ErrJSONType              error = &statusError{http.StatusBadRequest, "body contains incorrect JSON type"}
+	ErrUnknownField          error = &statusError{http.StatusBadRequest, "body contains unknown key"}
+	ErrDuplicateKey          error = &statusError{http.StatusBadRequest, "body contains duplicate key"}
+	ErrKeyCase               error = &statusError{http.StatusBadRequest, "body contains key with incorrect case"}
+	ErrMaxDepth              error = &statusError{http.StatusRequestEntityTooLarge, "body is nested too deeply"}
+	ErrMaxArrayLen           error = &statusError{http.StatusRequestEntityTooLarge, "body contains an array with too many elements"}
+	ErrMaxStringLen          error = &statusError{http.StatusRequestEntityTooLarge, "body contains a string that is too long"}
+	ErrMaxKeys               error = &statusError{http.StatusRequestEntityTooLarge, "body contains an object with too many keys"}
+	ErrNotAcceptable         error = &statusError{http.StatusNotAcceptable, "none of the available response formats is acceptable"}
+	ErrUnsupportedMediaType  error = &statusError{http.StatusUnsupportedMediaType, "unsupported content type"}
+	ErrUnsupportedEncoding   error = &statusError{http.StatusUnsupportedMediaType, "unsupported content encoding"}
+	ErrPreconditionFailed    error = &statusError{http.StatusPreconditionFailed, "precondition failed"}
+	ErrInvalidPatch          error = &statusError{http.StatusUnprocessableEntity, "patch cannot be applied"}
+	ErrPatchTestFailed       error = &statusError{http.StatusConflict, "patch test operation failed"}
+	ErrSchemaValidation      error = &statusError{http.StatusUnprocessableEntity, "body does not match the schema"}
+	ErrBadIdempotencyKey     error = &statusError{http.StatusBadRequest, "invalid idempotency key"}
+	ErrIdempotencyKeyReused  error = &statusError{http.StatusUnprocessableEntity, "idempotency key was already used for a different request"}
+	ErrIdempotencyInProgress error = &statusError{http.StatusConflict, "a request with this idempotency key is still being processed"}
+	ErrWebhookSignature      error = &statusError{http.StatusUnauthorized, "webhook signature is missing or invalid"}
+	ErrWebhookTimestamp      error = &statusError{http.StatusUnauthorized, "webhook timestamp is missing or outside the tolerance"}
+	ErrCircuitOpen           error = &statusError{http.StatusServiceUnavailable, "circuit breaker is open"}
+	ErrBadQuery              error = &statusError{http.StatusBadRequest, "query contains an invalid parameter"}
+	ErrInvalidCursor         error = &statusError{http.StatusBadRequest, "invalid pagination cursor"}
+	ErrBadBody               error = &statusError{http.StatusBadRequest, "body is badly-formed"}
+	ErrFileTooLarge          error = &statusError{http.StatusRequestEntityTooLarge, "the uploaded file is too big"}
+	ErrFileTypeNotPermitted  error = &statusError{http.StatusUnsupportedMediaType, "the uploaded file type is not permitted"}
+	ErrEmptySlugInput        error = &statusError{http.StatusBadRequest, "empty string not permited"}
+	ErrEmptySlug             error = &statusError{http.StatusBadRequest, "after removing special characters, slug is empty"}
+)
+
+// BodyTooLargeError is returned by ReadJSON when the body exceeds MaxJSONSize.
+type BodyTooLargeError struct {
+	Limit int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("body must not be larger than %d bytes", e.Limit)
+}
+
+func (e *BodyTooLargeError) Unwrap() error   { return ErrBodyTooLarge }
+func (e *BodyTooLargeError) HTTPStatus() int { return http.StatusRequestEntityTooLarge }
+
+// ProblemExtensions reports the size limit under the "limit" member.
+func (e *BodyTooLargeError) ProblemExtensions() map[string]any {
+	return map[string]any{"limit": e.Limit}
+}
+
+// Position locates a byte offset in a JSON body. Line and Column are 1-based, with Column
+// counted in characters, and Excerpt is a short piece of the offending line around that point.
+type Position struct {
+	Offset  int64  `json:"offset"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Excerpt string `json:"excerpt"`
+}
+
+// String describes the position for use in error messages.
+func (p Position) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("at character %d", p.Offset)
+	}
+
+	return fmt.Sprintf("at line %d, column %d, near %q", p.Line, p.Column, p.Excerpt)
+}
+
+// problemMembers returns the position as problem details extension members.
+func (p Position) problemMembers() map[string]any {
+	ext := map[string]any{"offset": p.Offset}
+	if p.Line != 0 {
+		ext["line"] = p.Line
+		ext["column"] = p.Column
+		ext["excerpt"] = p.Excerpt
+	}
+
+	return ext
+}
+
+// BadJSONError is returned by ReadJSON when the body is not well-formed JSON.
+// Offset is the byte offset at which the problem was detected, or 0 if it is not known.
+type BadJSONError struct {
+	Position
+}
+
+func (e *BadJSONError) Error() string {
+	if e.Offset == 0 {
+		return "body contains badly-formed JSON"
+	}
+
+	return fmt.Sprintf("body contains badly-formed JSON (%s)", e.Position)
+}
+
+func (e *BadJSONError) Unwrap() error   { return ErrBadJSON }
+func (e *BadJSONError) HTTPStatus() int { return http.StatusBadRequest }
+
+// ProblemExtensions reports the position of the problem, when known.
+func (e *BadJSONError) ProblemExtensions() map[string]any {
+	if e.Offset == 0 {
+		return nil
+	}
+
+	return e.problemMembers()
+}
+
+// JSONTypeError is returned by ReadJSON when a JSON value cannot be stored in the Go value
+// it is decoded into. Field is the dotted path of the field, if known.
+type JSONTypeError struct {
+	Position
+	Field string
+}
+
+func (e *JSONTypeError) Error() string {
+	if e.Field != "" {
+		if e.Line == 0 {
+			return fmt.Sprintf("body contains incorrect JSON type for field %q", e.Field)
+		}
+		return fmt.Sprintf("body contains incorrect JSON type for field %q (%s)", e.Field, e.Position)
+	}
+
+	return fmt.Sprintf("body contains incorrect JSON type (%s)", e.Position)
+}
+
+func (e *JSONTypeError) Unwrap() error   { return ErrJSONType }
+func (e *JSONTypeError) HTTPStatus() int { return http.StatusBadRequest }
+
+// ProblemExtensions reports the field and position of the mismatch.
+func (e *JSONTypeError) ProblemExtensions() map[string]any {
+	ext := e.problemMembers()
+	if e.Field != "" {
+		ext["field"] = e.Field
+	}
+
+	return ext
+}
+
+// excerptRadius is the number of characters either side of an error kept in Position.Excerpt.
+const excerptRadius = 20
+
+// jsonPosition locates offset, as reported by encoding/json (the number of bytes read when the
+// error was detected), in data. The position points at the last byte read.
+func jsonPosition(data []byte, offset int64) Position {
+	pos := Position{Offset: offset}
+	if len(data) == 0 {
+		return pos
+	}
+
+	at := int(offset) - 1
+	if at < 0 {
+		at = 0
+	}
+	if at >= len(data) {
+		at = len(data) - 1
+	}
+
+	lineStart := bytes.LastIndexByte(data[:at], '\n') + 1
+	lineEnd := len(data)
+	if i := bytes.IndexByte(data[at:], '\n'); i >= 0 {
+		lineEnd = at + i
+	}
+
+	pos.Line = bytes.Count(data[:lineStart], []byte{'\n'}) + 1
+
+	before := []rune(string(data[lineStart:at]))
+	after := []rune(string(data[at:lineEnd]))
+	pos.Column = len(before) + 1
+
+	if len(before) > excerptRadius {
+		before = before[len(before)-excerptRadius:]
+	}
+	if len(after) > excerptRadius+1 {
+		after = after[:excerptRadius+1]
+	}
+	pos.Excerpt = strings.TrimSpace(string(before) + string(after))
+
+	return pos
+}
+
+// UnknownFieldError is returned by ReadJSON when the body contains a key that does not
+// match any field of the destination and AllowUnknownFields is false. Field is the bare key,
+// without quotes.
+type UnknownFieldError struct {
+	Field string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("body contains unknown key %q", e.Field)
+}
+
+func (e *UnknownFieldError) Unwrap() error   { return ErrUnknownField }
+func (e *UnknownFieldError) HTTPStatus() int { return http.StatusBadRequest }
+
+// ProblemExtensions reports the unknown key under the "field" member.
+func (e *UnknownFieldError) ProblemExtensions() map[string]any {
+	return map[string]any{"field": e.Field}
+}
+
+// DuplicateKeyError is returned by ReadJSON, when StrictJSON is set, if an object in the body
+// has the same key more than once. Path is the dotted path of the repeated key, e.g. "items[0].amount".
+type DuplicateKeyError struct {
+	Path string
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return fmt.Sprintf("body contains duplicate key %q", e.Path)
+}
+
+func (e *DuplicateKeyError) Unwrap() error   { return ErrDuplicateKey }
+func (e *DuplicateKeyError) HTTPStatus() int { return http.StatusBadRequest }
+
+// ProblemExtensions reports the path of the repeated key under the "path" member.
+func (e *DuplicateKeyError) ProblemExtensions() map[string]any {
+	return map[string]any{"path": e.Path}
+}
+
+// KeyCaseError is returned by ReadJSON, when StrictJSON is set, if a key in the body only matches
+// a field of the destination case-insensitively. Field is the JSON name the key should have used.
+type KeyCaseError struct {
+	Path  string
+	Field string
+}
+
+func (e *KeyCaseError) Error() string {
+	return fmt.Sprintf("body contains key %q which does not exactly match field %q", e.Path, e.Field)
+}
+
+func (e *KeyCaseError) Unwrap() error   { return ErrKeyCase }
+func (e *KeyCaseError) HTTPStatus() int { return http.StatusBadRequest }
+
+// ProblemExtensions reports the path of the key and the expected field name.
+func (e *KeyCaseError) ProblemExtensions() map[string]any {
+	return map[string]any{"path": e.Path, "field": e.Field}
+}
+
+// JSONLimitError is returned by ReadJSON when the body exceeds one of the MaxDepth, MaxArrayLen,
+// MaxStringLen or MaxKeys limits. Err is the matching sentinel (ErrMaxDepth, ErrMaxArrayLen,
+// ErrMaxStringLen or ErrMaxKeys), and Path is the dotted path of the offending value.
+type JSONLimitError struct {
+	Err   error
+	Path  string
+	Limit int
+}
+
+func (e *JSONLimitError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s (limit %d)", e.Err, e.Limit)
+	}
+
+	return fmt.Sprintf("%s at %q (limit %d)", e.Err, e.Path, e.Limit)
+}
+
+func (e *JSONLimitError) Unwrap() error   { return e.Err }
+func (e *JSONLimitError) HTTPStatus() int { return http.StatusRequestEntityTooLarge }
+
+// ProblemExtensions reports the path of the offending value and the limit it exceeded.
+func (e *JSONLimitError) ProblemExtensions() map[string]any {
+	return map[string]any{"path": e.Path, "limit": e.Limit}
+}
+
+// FileTooLargeError is returned by UploadFiles when the multipart form exceeds MaxFileSize.
+type FileTooLargeError struct {
+	Limit int64
+	Err   error
+}
+
+func (e *FileTooLargeError) Error() string { return ErrFileTooLarge.Error() }
+
+// Unwrap returns both ErrFileTooLarge and the underlying multipart error.
+func (e *FileTooLargeError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrFileTooLarge}
+	}
+
+	return []error{ErrFileTooLarge, e.Err}
+}
+
+func (e *FileTooLargeError) HTTPStatus() int { return http.StatusRequestEntityTooLarge }
+
+// ProblemExtensions reports the size limit under the "limit" member.
+func (e *FileTooLargeError) ProblemExtensions() map[string]any {
+	return map[string]any{"limit": e.Limit}
+}
+
+// FileTypeError is returned by UploadFiles when an uploaded file's detected content type
+// is not one of AllowedFileTypes.
+type FileTypeError struct {
+	FileName     string
+	ContentType  string
+	AllowedTypes []string
+}
+
+func (e *FileTypeError) Error() string { return ErrFileTypeNotPermitted.Error() }
+
+func (e *FileTypeError) Unwrap() error   { return ErrFileTypeNotPermitted }
+func (e *FileTypeError) HTTPStatus() int { return http.StatusUnsupportedMediaType }
+
+// ProblemExtensions reports the offending file, its type and the permitted types.
+func (e *FileTypeError) ProblemExtensions() map[string]any {
+	return map[string]any{
+		"file_name":     e.FileName,
+		"content_type":  e.ContentType,
+		"allowed_types": e.AllowedTypes,
+	}
+}
+
+// HTTPStatus reports 422 Unprocessable Entity for failed validation.
+func (v ValidationErrors) HTTPStatus() int { return http.StatusUnprocessableEntity }
+
+// HTTPStatus reports the problem's own status code.
+func (p *Problem) HTTPStatus() int { return p.Status }
+
+// statusFromError returns the status code of the first HTTPStatusError in err's chain, or fallback.
+func statusFromError(err error, fallback int) int {
+	var se HTTPStatusError
+	if errors.As(err, &se) && se.HTTPStatus() != 0 {
+		return se.HTTPStatus()
+	}
+
+	return fallback
+}
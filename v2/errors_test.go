@@ -0,0 +1,170 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var typedJSONErrorTests = []struct {
+	name           string
+	json           string
+	maxSize        int
+	sentinel       error
+	expectedStatus int
+}{
+	{name: "empty body", json: ``, maxSize: 1024, sentinel: ErrEmptyBody, expectedStatus: http.StatusBadRequest},
+	{name: "syntax error", json: `{"foo": 1"}`, maxSize: 1024, sentinel: ErrBadJSON, expectedStatus: http.StatusBadRequest},
+	{name: "unexpected eof", json: `{"foo": "bar"`, maxSize: 1024, sentinel: ErrBadJSON, expectedStatus: http.StatusBadRequest},
+	{name: "incorrect type", json: `{"foo": 1}`, maxSize: 1024, sentinel: ErrJSONType, expectedStatus: http.StatusBadRequest},
+	{name: "unknown field", json: `{"fooooo": "bar"}`, maxSize: 1024, sentinel: ErrUnknownField, expectedStatus: http.StatusBadRequest},
+	{name: "too large", json: `{"foo": "bar"}`, maxSize: 4, sentinel: ErrBodyTooLarge, expectedStatus: http.StatusRequestEntityTooLarge},
+	{name: "two values", json: `{"foo": "1"}{"foo":"2"}`, maxSize: 1024, sentinel: ErrMultipleJSONValues, expectedStatus: http.StatusBadRequest},
+}
+
+func TestTools_ReadJSONTypedErrors(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range typedJSONErrorTests {
+		t.Run(e.name, func(t *testing.T) {
+			testTools.MaxJSONSize = e.maxSize
+
+			var decodedJSON struct {
+				Foo string `json:"foo"`
+			}
+			req, err := http.NewRequest("POST", "/", bytes.NewReader([]byte(e.json)))
+			assert.NoError(t, err)
+
+			err = testTools.ReadJSON(httptest.NewRecorder(), req, &decodedJSON)
+			assert.ErrorIs(t, err, e.sentinel)
+
+			var statusErr HTTPStatusError
+			assert.True(t, errors.As(err, &statusErr))
+			assert.Equal(t, e.expectedStatus, statusErr.HTTPStatus())
+
+			rr := httptest.NewRecorder()
+			assert.NoError(t, testTools.ErrorJSON(rr, err))
+			assert.Equal(t, e.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestTools_ReadJSONErrorDetails(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 1024}
+
+	var decodedJSON struct {
+		Foo string `json:"foo"`
+	}
+
+	req, _ := http.NewRequest("POST", "/", bytes.NewReader([]byte(`{"foo": 1"}`)))
+	err := testTools.ReadJSON(httptest.NewRecorder(), req, &decodedJSON)
+	var badJSON *BadJSONError
+	assert.True(t, errors.As(err, &badJSON))
+	assert.Equal(t, int64(10), badJSON.Offset)
+	assert.Equal(t, 1, badJSON.Line)
+	assert.Equal(t, 10, badJSON.Column)
+
+	req, _ = http.NewRequest("POST", "/", bytes.NewReader([]byte(`{"bar": 1}`)))
+	err = testTools.ReadJSON(httptest.NewRecorder(), req, &decodedJSON)
+	var unknown *UnknownFieldError
+	assert.True(t, errors.As(err, &unknown))
+	assert.Equal(t, "bar", unknown.Field)
+}
+
+func TestTools_ErrorJSONTypedErrors(t *testing.T) {
+	testTools := Tools{UseProblemDetails: true}
+
+	rr := httptest.NewRecorder()
+	err := &FileTypeError{FileName: "a.exe", ContentType: "application/octet-stream", AllowedTypes: []string{pngType}}
+	assert.NoError(t, testTools.ErrorJSON(rr, err))
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+
+	var p Problem
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&p))
+	assert.Equal(t, "the uploaded file type is not permitted", p.Detail)
+	assert.Equal(t, "application/octet-stream", p.Extensions["content_type"])
+
+	// an explicit status still takes precedence
+	rr = httptest.NewRecorder()
+	assert.NoError(t, testTools.ErrorJSON(rr, ErrFileTooLarge, http.StatusBadRequest))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestTools_SlugifyTypedErrors(t *testing.T) {
+	var testTools Tools
+
+	_, err := testTools.Slugify("")
+	assert.ErrorIs(t, err, ErrEmptySlugInput)
+
+	_, err = testTools.Slugify("こんにちば")
+	assert.ErrorIs(t, err, ErrEmptySlug)
+}
+
+var positionTests = []struct {
+	name            string
+	json            string
+	sentinel        error
+	expectedLine    int
+	expectedColumn  int
+	expectedExcerpt string
+}{
+	{
+		name:            "syntax error on second line",
+		json:            "{\n  \"foo\": 1\"\n}",
+		sentinel:        ErrBadJSON,
+		expectedLine:    2,
+		expectedColumn:  11,
+		expectedExcerpt: `"foo": 1"`,
+	},
+	{
+		name:            "type error on third line",
+		json:            "{\n  \"bar\": \"x\",\n  \"foo\": 12345\n}",
+		sentinel:        ErrJSONType,
+		expectedLine:    3,
+		expectedColumn:  14,
+		expectedExcerpt: `"foo": 12345`,
+	},
+	{
+		name:            "unexpected end of input",
+		json:            "{\n  \"foo\": \"bar\"",
+		sentinel:        ErrBadJSON,
+		expectedLine:    2,
+		expectedColumn:  14,
+		expectedExcerpt: `"foo": "bar"`,
+	},
+}
+
+func TestTools_ReadJSONErrorPosition(t *testing.T) {
+	testTools := Tools{AllowUnknownFields: true}
+
+	for _, e := range positionTests {
+		t.Run(e.name, func(t *testing.T) {
+			var decodedJSON struct {
+				Foo string `json:"foo"`
+			}
+			req, _ := http.NewRequest("POST", "/", bytes.NewReader([]byte(e.json)))
+			err := testTools.ReadJSON(httptest.NewRecorder(), req, &decodedJSON)
+			assert.ErrorIs(t, err, e.sentinel)
+
+			var pos Position
+			var badJSON *BadJSONError
+			var typeErr *JSONTypeError
+			switch {
+			case errors.As(err, &badJSON):
+				pos = badJSON.Position
+			case errors.As(err, &typeErr):
+				pos = typeErr.Position
+			}
+
+			assert.Equal(t, e.expectedLine, pos.Line)
+			assert.Equal(t, e.expectedColumn, pos.Column)
+			assert.Equal(t, e.expectedExcerpt, pos.Excerpt)
+			assert.Contains(t, err.Error(), "line")
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETag returns the strong entity tag WriteJSON sends for data when ETags is set, whatever the
+// Envelope, as the tag is computed before the envelope wraps the data. It differs when fields are
+// pruned by AllowedFields or SparseFields, since the tag is then that of the pruned data, and for
+// Write with a codec other than JSON. Use it to compute the current tag of a resource for
+// CheckIfMatch.
+func ETag(data any) (string, error) {
+	out, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	return etagFor(out), nil
+}
+
+// etagFor returns a strong entity tag for an encoded body.
+func etagFor(out []byte) string {
+	sum := sha256.Sum256(out)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:]) + `"`
+}
+
+// encodedETag returns the tag for a representation compressed with encoding. A strong tag must
+// differ between representations, so the encoding is appended to it; weak tags are unchanged.
+func encodedETag(etag, encoding string) string {
+	if etag == "" || strings.HasPrefix(etag, "W/") || !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// opaqueTag returns the opaque part of an entity tag, without any W/ prefix or encoding suffix
+// added by encodedETag, and whether the tag was weak.
+func (t *Tools) opaqueTag(etag string) (string, bool) {
+	etag = strings.TrimSpace(etag)
+	weak := strings.HasPrefix(etag, "W/")
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, enc := range t.contentEncodings() {
+		suffix := "-" + enc.Name() + `"`
+		if strings.HasSuffix(etag, suffix) {
+			etag = strings.TrimSuffix(etag, suffix) + `"`
+			break
+		}
+	}
+
+	return etag, weak
+}
+
+// etagMatches reports whether current matches any tag in the list header, which is the value of
+// If-Match or If-None-Match. Weak comparison ignores the W/ prefix; strong comparison never
+// matches a weak tag.
+func (t *Tools) etagMatches(list, current string, weakComparison bool) bool {
+	if current == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+
+	cur, curWeak := t.opaqueTag(current)
+	if curWeak && !weakComparison {
+		return false
+	}
+
+	for _, candidate := range strings.Split(list, ",") {
+		tag, weak := t.opaqueTag(candidate)
+		if weak && !weakComparison {
+			continue
+		}
+		if tag == cur {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified reports whether a GET or HEAD request's If-None-Match or If-Modified-Since
+// precondition means the response, whose headers are in h, can be replaced with 304 Not Modified.
+func (t *Tools) notModified(r *http.Request, h http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return t.etagMatches(inm, h.Get("ETag"), true)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	lm := h.Get("Last-Modified")
+	if ims == "" || lm == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lm)
+	if err != nil {
+		return false
+	}
+
+	return !modified.After(since)
+}
+
+// writeNotModified answers with 304 Not Modified, keeping the validator and caching headers
+// but dropping those that describe the omitted body.
+func (t *Tools) writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	for _, key := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+		h.Del(key)
+	}
+	if t.Compress {
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// CheckIfMatch evaluates the If-Match and If-Unmodified-Since preconditions of a state-changing
+// request against the current entity tag of the resource, as returned by ETag, and optionally its
+// last modification time. It returns a *PreconditionFailedError, which ErrorJSON reports as
+// 412 Precondition Failed, if they do not hold. An empty currentETag means the resource does not
+// exist, so only a request without If-Match can succeed.
+func (t *Tools) CheckIfMatch(r *http.Request, currentETag string, lastModified ...time.Time) error {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !t.etagMatches(im, currentETag, false) {
+			return &PreconditionFailedError{Header: "If-Match", Value: im}
+		}
+		return nil
+	}
+
+	ius := r.Header.Get("If-Unmodified-Since")
+	if ius == "" || len(lastModified) == 0 {
+		return nil
+	}
+
+	since, err := http.ParseTime(ius)
+	if err != nil {
+		return nil
+	}
+	if lastModified[0].Truncate(time.Second).After(since) {
+		return &PreconditionFailedError{Header: "If-Unmodified-Since", Value: ius}
+	}
+
+	return nil
+}
+
+// PreconditionFailedError is returned by CheckIfMatch when a request's precondition does not hold.
+type PreconditionFailedError struct {
+	Header string
+	Value  string
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return fmt.Sprintf("precondition failed: %s %s", e.Header, e.Value)
+}
+
+func (e *PreconditionFailedError) Unwrap() error   { return ErrPreconditionFailed }
+func (e *PreconditionFailedError) HTTPStatus() int { return http.StatusPreconditionFailed }
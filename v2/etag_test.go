@@ -0,0 +1,203 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTools_WriteJSONETag(t *testing.T) {
+	testTools := Tools{ETags: true}
+	payload := JSONResponse{Message: "foo"}
+
+	etag, err := ETag(payload)
+	assert.NoError(t, err)
+
+	var etagTests = []struct {
+		name           string
+		method         string
+		ifNoneMatch    string
+		expectedStatus int
+	}{
+		{name: "no precondition", method: "GET", ifNoneMatch: "", expectedStatus: http.StatusOK},
+		{name: "matching tag", method: "GET", ifNoneMatch: etag, expectedStatus: http.StatusNotModified},
+		{name: "matching weak tag", method: "GET", ifNoneMatch: `"other", W/` + etag, expectedStatus: http.StatusNotModified},
+		{name: "wildcard", method: "HEAD", ifNoneMatch: "*", expectedStatus: http.StatusNotModified},
+		{name: "stale tag", method: "GET", ifNoneMatch: `"stale"`, expectedStatus: http.StatusOK},
+		{name: "not a GET", method: "POST", ifNoneMatch: etag, expectedStatus: http.StatusOK},
+	}
+
+	for _, e := range etagTests {
+		t.Run(e.name, func(t *testing.T) {
+			req, _ := http.NewRequest(e.method, "/", nil)
+			if e.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", e.ifNoneMatch)
+			}
+
+			rr := httptest.NewRecorder()
+			err := testTools.WriteJSON(testTools.WithRequest(rr, req), http.StatusOK, payload)
+			assert.NoError(t, err)
+			assert.Equal(t, e.expectedStatus, rr.Code)
+			assert.Equal(t, etag, rr.Header().Get("ETag"))
+
+			if e.expectedStatus == http.StatusNotModified {
+				assert.Empty(t, rr.Body.String())
+				assert.Empty(t, rr.Header().Get("Content-Type"))
+			} else {
+				assert.NotEmpty(t, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestTools_WriteJSONExplicitValidators(t *testing.T) {
+	var testTools Tools
+
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	headers := make(http.Header)
+	headers.Set("ETag", `"v42"`)
+	headers.Set("Last-Modified", modified.Format(http.TimeFormat))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", `"v42"`)
+	rr := httptest.NewRecorder()
+	assert.NoError(t, testTools.WriteJSON(testTools.WithRequest(rr, req), http.StatusOK, JSONResponse{}, headers))
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("If-Modified-Since", modified.Add(time.Hour).Format(http.TimeFormat))
+	rr = httptest.NewRecorder()
+	assert.NoError(t, testTools.WriteJSON(testTools.WithRequest(rr, req), http.StatusOK, JSONResponse{}, headers))
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat))
+	rr = httptest.NewRecorder()
+	assert.NoError(t, testTools.WriteJSON(testTools.WithRequest(rr, req), http.StatusOK, JSONResponse{}, headers))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestTools_WriteJSONETagCompressed(t *testing.T) {
+	testTools := Tools{ETags: true, Compress: true}
+	payload := JSONResponse{Message: strings.Repeat("a", 2000)}
+
+	etag, _ := ETag(payload)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	assert.NoError(t, testTools.WriteJSON(testTools.WithRequest(rr, req), http.StatusOK, payload))
+	gzipETag := rr.Header().Get("ETag")
+	assert.NotEqual(t, etag, gzipETag)
+	assert.True(t, strings.HasSuffix(gzipETag, `-gzip"`))
+
+	req.Header.Set("If-None-Match", gzipETag)
+	rr = httptest.NewRecorder()
+	assert.NoError(t, testTools.WriteJSON(testTools.WithRequest(rr, req), http.StatusOK, payload))
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+
+	// the compressed representation's tag still satisfies If-Match for the resource
+	req, _ = http.NewRequest("PUT", "/", nil)
+	req.Header.Set("If-Match", gzipETag)
+	assert.NoError(t, testTools.CheckIfMatch(req, etag))
+}
+
+func TestTools_CheckIfMatch(t *testing.T) {
+	var testTools Tools
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var ifMatchTests = []struct {
+		name              string
+		ifMatch           string
+		ifUnmodifiedSince string
+		current           string
+		errorExpected     bool
+	}{
+		{name: "no precondition", current: `"a"`},
+		{name: "matching", ifMatch: `"a"`, current: `"a"`},
+		{name: "one of several", ifMatch: `"b", "a"`, current: `"a"`},
+		{name: "stale", ifMatch: `"b"`, current: `"a"`, errorExpected: true},
+		{name: "weak tags never match", ifMatch: `W/"a"`, current: `"a"`, errorExpected: true},
+		{name: "wildcard", ifMatch: "*", current: `"a"`},
+		{name: "wildcard on missing resource", ifMatch: "*", current: "", errorExpected: true},
+		{name: "unmodified", ifUnmodifiedSince: modified.Format(http.TimeFormat), current: `"a"`},
+		{name: "modified since", ifUnmodifiedSince: modified.Add(-time.Minute).Format(http.TimeFormat), current: `"a"`, errorExpected: true},
+	}
+
+	for _, e := range ifMatchTests {
+		t.Run(e.name, func(t *testing.T) {
+			req, _ := http.NewRequest("PUT", "/", nil)
+			if e.ifMatch != "" {
+				req.Header.Set("If-Match", e.ifMatch)
+			}
+			if e.ifUnmodifiedSince != "" {
+				req.Header.Set("If-Unmodified-Since", e.ifUnmodifiedSince)
+			}
+
+			err := testTools.CheckIfMatch(req, e.current, modified)
+			if !e.errorExpected {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorIs(t, err, ErrPreconditionFailed)
+
+			rr := httptest.NewRecorder()
+			assert.NoError(t, testTools.ErrorJSON(rr, err))
+			assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+		})
+	}
+}
+
+func TestTools_WriteJSONETagEnveloped(t *testing.T) {
+	testTools := Tools{ETags: true, Envelope: StandardEnvelope}
+	payload := JSONResponse{Message: "foo"}
+	etag, _ := ETag(payload)
+
+	handler := testTools.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = testTools.WriteJSON(w, http.StatusOK, payload)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"request_id"`)
+	assert.Equal(t, etag, rr.Header().Get("ETag"))
+
+	// the request ID and duration in the envelope differ, but the tag does not
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+}
+
+func TestETag_MatchesWriteJSON(t *testing.T) {
+	payload := JSONResponse{Error: true, Message: "foo"}
+
+	var etagWriteTests = []struct {
+		name   string
+		tools  Tools
+		tagged any
+	}{
+		{name: "default envelope", tools: Tools{ETags: true}, tagged: payload},
+		{name: "standard envelope", tools: Tools{ETags: true, Envelope: StandardEnvelope}, tagged: payload},
+		{name: "pruned fields", tools: Tools{ETags: true, AllowedFields: "message"}, tagged: map[string]string{"message": "foo"}},
+	}
+
+	for _, e := range etagWriteTests {
+		t.Run(e.name, func(t *testing.T) {
+			etag, err := ETag(e.tagged)
+			assert.NoError(t, err)
+
+			rr := httptest.NewRecorder()
+			err = e.tools.WriteJSON(e.tools.WithRequest(rr, httptest.NewRequest("GET", "/", nil)), http.StatusOK, payload)
+			assert.NoError(t, err)
+			assert.Equal(t, etag, rr.Header().Get("ETag"))
+		})
+	}
+}
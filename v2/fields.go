@@ -0,0 +1,174 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// FieldSet is a set of JSON field paths, such as those in ?fields=id,name,owner.email. Each key
+// names a field kept at this level, mapped to the fields kept within it, or to nil if the whole
+// field is kept. A nil FieldSet keeps everything.
+type FieldSet map[string]FieldSet
+
+// ParseFieldSet parses a comma separated list of dot separated field paths. Naming a field keeps
+// all of it, so "owner,owner.email" is the same as "owner". An empty list gives a nil FieldSet.
+func ParseFieldSet(s string) (FieldSet, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	fs := FieldSet{}
+	for _, path := range strings.Split(s, ",") {
+		path = strings.TrimSpace(path)
+		segments := strings.Split(path, ".")
+		for _, seg := range segments {
+			if seg == "" {
+				return nil, fmt.Errorf("invalid field path %q", path)
+			}
+		}
+
+		node := fs
+		for i, seg := range segments {
+			child, ok := node[seg]
+			if ok && child == nil {
+				// the whole field is already kept
+				break
+			}
+			if i == len(segments)-1 {
+				node[seg] = nil
+				break
+			}
+			if !ok {
+				child = FieldSet{}
+				node[seg] = child
+			}
+			node = child
+		}
+	}
+
+	return fs, nil
+}
+
+// Intersect returns the fields kept by both fs and other.
+func (fs FieldSet) Intersect(other FieldSet) FieldSet {
+	if fs == nil {
+		return other
+	}
+	if other == nil {
+		return fs
+	}
+
+	out := FieldSet{}
+	for name, sub := range fs {
+		otherSub, ok := other[name]
+		if !ok {
+			continue
+		}
+		out[name] = sub.Intersect(otherSub)
+	}
+
+	return out
+}
+
+// Filter prunes an encoded JSON value to the fields in fs. The fields of an array are applied to
+// each of its elements, and the order of the remaining keys is preserved.
+func (fs FieldSet) Filter(data []byte) ([]byte, error) {
+	if fs == nil {
+		return data, nil
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return data, nil
+	}
+
+	switch trimmed[0] {
+	case '[':
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, item := range items {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			out, err := fs.Filter(item)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(out)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), nil
+
+	case '{':
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		first := true
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ := tok.(string)
+
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				return nil, err
+			}
+
+			sub, ok := fs[key]
+			if !ok {
+				continue
+			}
+			if value, err = sub.Filter(value); err != nil {
+				return nil, err
+			}
+
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			name, _ := json.Marshal(key)
+			buf.Write(name)
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+		buf.WriteByte('}')
+		return buf.Bytes(), nil
+	}
+
+	return trimmed, nil
+}
+
+// responseFields returns the fields a successful WriteJSON response to w should be pruned to:
+// those of the fields query parameter, when SparseFields is set and w comes from WithRequest,
+// within the AllowedFields allow-list. It returns nil when nothing should be pruned.
+func (t *Tools) responseFields(w http.ResponseWriter) (FieldSet, error) {
+	allowed, err := ParseFieldSet(t.AllowedFields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AllowedFields: %w", err)
+	}
+
+	var requested FieldSet
+	if r := requestOf(w); t.SparseFields && r != nil {
+		values := r.URL.Query()["fields"]
+		requested, err = ParseFieldSet(strings.Join(values, ","))
+		if err != nil {
+			return nil, &QueryParamError{Source: "query", Param: "fields", Value: strings.Join(values, ","), Message: "must be a comma separated list of field paths"}
+		}
+	}
+
+	return requested.Intersect(allowed), nil
+}
@@ -0,0 +1,135 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var parseFieldSetTests = []struct {
+	name          string
+	fields        string
+	expected      FieldSet
+	errorExpected bool
+}{
+	{name: "empty", fields: "", expected: nil},
+	{name: "flat", fields: "id, name", expected: FieldSet{"id": nil, "name": nil}},
+	{name: "nested", fields: "id,owner.email,owner.name", expected: FieldSet{"id": nil, "owner": FieldSet{"email": nil, "name": nil}}},
+	{name: "whole field wins", fields: "owner.email,owner", expected: FieldSet{"owner": nil}},
+	{name: "whole field first", fields: "owner,owner.email", expected: FieldSet{"owner": nil}},
+	{name: "empty segment", fields: "owner..email", errorExpected: true},
+	{name: "trailing comma", fields: "id,", errorExpected: true},
+}
+
+func TestParseFieldSet(t *testing.T) {
+	for _, e := range parseFieldSetTests {
+		t.Run(e.name, func(t *testing.T) {
+			fs, err := ParseFieldSet(e.fields)
+			if e.errorExpected {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, e.expected, fs)
+		})
+	}
+}
+
+var filterTests = []struct {
+	name     string
+	fields   string
+	input    string
+	expected string
+}{
+	{name: "object", fields: "name,id", input: `{"id":1,"name":"a","secret":"x"}`, expected: `{"id":1,"name":"a"}`},
+	{name: "nested", fields: "id,owner.email", input: `{"id":1,"owner":{"email":"e","name":"n"}}`, expected: `{"id":1,"owner":{"email":"e"}}`},
+	{name: "array", fields: "id", input: `[{"id":1,"x":2},{"id":3,"x":4}]`, expected: `[{"id":1},{"id":3}]`},
+	{name: "nested array", fields: "tags.name", input: `{"tags":[{"name":"a","id":1}],"id":1}`, expected: `{"tags":[{"name":"a"}]}`},
+	{name: "scalar under path", fields: "id.x", input: `{"id":1}`, expected: `{"id":1}`},
+	{name: "null", fields: "owner.email", input: `{"owner":null}`, expected: `{"owner":null}`},
+}
+
+func TestFieldSet_Filter(t *testing.T) {
+	for _, e := range filterTests {
+		t.Run(e.name, func(t *testing.T) {
+			fs, _ := ParseFieldSet(e.fields)
+			out, err := fs.Filter([]byte(e.input))
+			assert.NoError(t, err)
+			assert.Equal(t, e.expected, string(out))
+		})
+	}
+}
+
+func TestFieldSet_Intersect(t *testing.T) {
+	requested, _ := ParseFieldSet("id,owner,secret")
+	allowed, _ := ParseFieldSet("id,name,owner.email")
+
+	assert.Equal(t, FieldSet{"id": nil, "owner": FieldSet{"email": nil}}, requested.Intersect(allowed))
+	assert.Equal(t, allowed, FieldSet(nil).Intersect(allowed))
+}
+
+type fieldsOwner struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type fieldsItem struct {
+	ID    int         `json:"id"`
+	Name  string      `json:"name"`
+	Owner fieldsOwner `json:"owner"`
+}
+
+var writeJSONFieldsTests = []struct {
+	name     string
+	tools    Tools
+	query    string
+	status   int
+	expected string
+}{
+	{name: "disabled", tools: Tools{}, query: "fields=id", status: http.StatusOK, expected: `{"id":1,"name":"a","owner":{"email":"e","name":"n"}}`},
+	{name: "sparse", tools: Tools{SparseFields: true}, query: "fields=id,owner.email", status: http.StatusOK, expected: `{"id":1,"owner":{"email":"e"}}`},
+	{name: "no fields param", tools: Tools{SparseFields: true}, query: "", status: http.StatusOK, expected: `{"id":1,"name":"a","owner":{"email":"e","name":"n"}}`},
+	{name: "allow-list", tools: Tools{AllowedFields: "id,name"}, query: "", status: http.StatusOK, expected: `{"id":1,"name":"a"}`},
+	{name: "allow-list limits request", tools: Tools{SparseFields: true, AllowedFields: "id,name"}, query: "fields=id,owner", status: http.StatusOK, expected: `{"id":1}`},
+	{name: "errors are not pruned", tools: Tools{SparseFields: true}, query: "fields=id", status: http.StatusBadRequest, expected: `{"id":1,"name":"a","owner":{"email":"e","name":"n"}}`},
+}
+
+func TestTools_WriteJSON_Fields(t *testing.T) {
+	item := fieldsItem{ID: 1, Name: "a", Owner: fieldsOwner{Email: "e", Name: "n"}}
+
+	for _, e := range writeJSONFieldsTests {
+		t.Run(e.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/items/1?"+e.query, nil)
+			rr := httptest.NewRecorder()
+
+			err := e.tools.WriteJSON(e.tools.WithRequest(rr, req), e.status, item)
+			assert.NoError(t, err)
+			assert.Equal(t, e.status, rr.Code)
+			assert.Equal(t, e.expected, rr.Body.String())
+		})
+	}
+}
+
+func TestTools_WriteJSON_FieldsPage(t *testing.T) {
+	testTools := Tools{SparseFields: true}
+	req := httptest.NewRequest("GET", "/items?fields=id", nil)
+	rr := httptest.NewRecorder()
+
+	page := NewOffsetPage(req, PageRequest{Limit: 1}, []fieldsItem{{ID: 1, Name: "a"}}, 2)
+	assert.NoError(t, testTools.WriteJSON(testTools.WithRequest(rr, req), http.StatusOK, page))
+
+	assert.Contains(t, rr.Body.String(), `{"data":[{"id":1}],"meta":{"limit":1,"offset":0,"total":2},"links":{"first":`)
+	assert.NotEmpty(t, rr.Header().Get("Link"))
+}
+
+func TestTools_WriteJSON_BadFields(t *testing.T) {
+	testTools := Tools{SparseFields: true}
+	req := httptest.NewRequest("GET", "/items?fields=owner..email", nil)
+	rr := httptest.NewRecorder()
+
+	err := testTools.WriteJSON(testTools.WithRequest(rr, req), http.StatusOK, fieldsItem{})
+	assert.ErrorIs(t, err, ErrBadQuery)
+	assert.Equal(t, 0, rr.Body.Len())
+}
@@ -0,0 +1,528 @@
+package toolkit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKey        = 255
+	defaultIdempotencyTTL    = 24 * time.Hour
+)
+
+// WithIdempotencyKey sends key in the Idempotency-Key header, which also lets a RetryPolicy retry
+// POST and PATCH requests. An empty key means a random one is generated; either way the same key
+// is sent with every attempt.
+func WithIdempotencyKey(key string) RequestOption {
+	return func(o *requestOptions) {
+		if key == "" {
+			o.newIdempotencyKey = true
+			return
+		}
+		o.header.Set(idempotencyKeyHeader, key)
+	}
+}
+
+// IdempotencyRecord is what an IdempotencyStore keeps for a key: the fingerprint of the first
+// request made with it and, once that request has been handled, its response.
+type IdempotencyRecord struct {
+	Fingerprint string      `json:"fingerprint"`
+	Completed   bool        `json:"completed"`
+	StatusCode  int         `json:"status_code,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// IdempotencyStore keeps the records used by Idempotent. Implementations must be safe for
+// concurrent use, and should forget records some time after they are created.
+type IdempotencyStore interface {
+	// Reserve stores rec under key and returns nil if there is no record for key yet. Otherwise
+	// it stores nothing and returns the existing record.
+	Reserve(key string, rec *IdempotencyRecord) (*IdempotencyRecord, error)
+	// Complete replaces the record for key with rec, which holds the response.
+	Complete(key string, rec *IdempotencyRecord) error
+	// Release removes the record for key, so that the request can be made again.
+	Release(key string) error
+}
+
+// IdempotencyOptions configures Idempotent.
+type IdempotencyOptions struct {
+	// Scope returns the caller a request is made by, such as the ID of its authenticated user or
+	// API key, which is combined with the Idempotency-Key so that each caller has keys of its own.
+	// Without it, keys are shared by everyone, and a client that learns or guesses another's key
+	// is given that client's response; Scope should be set whenever requests are authenticated.
+	// It is called after any authentication middleware that wraps Idempotent has run. nil means
+	// keys are not scoped.
+	Scope func(r *http.Request) string
+
+	// MaxResponseSize is the largest response body, in bytes, that is kept. A larger response is
+	// passed on without being kept, and the key is released. 0 means MaxJSONSize.
+	MaxResponseSize int64
+}
+
+// Idempotent wraps next so that POST and PATCH requests with an Idempotency-Key header are handled
+// at most once. The first response for a key is kept in store and replayed, with an
+// Idempotent-Replayed header, for later requests with the same key. A key that is reused with a
+// different method, URL or body is rejected with ErrIdempotencyKeyReused, and one whose first
+// request is still being handled with ErrIdempotencyInProgress. Responses with a 5xx status, or
+// larger than IdempotencyOptions.MaxResponseSize, are not kept, so that the request can be retried. Compressed responses are kept uncompressed, and
+// compressed again when replayed for a client that accepts it. Request bodies may be at most MaxJSONSize bytes.
+// Keys are scoped to the caller by IdempotencyOptions.Scope; the final parameter, opts, is optional.
+func (t *Tools) Idempotent(store IdempotencyStore, next http.Handler, opts ...IdempotencyOptions) http.Handler {
+	var opt IdempotencyOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	maxResponse := opt.MaxResponseSize
+	if maxResponse <= 0 {
+		maxResponse = t.maxJSONBytes()
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			_ = t.ErrorJSON(w, ErrBadIdempotencyKey)
+			return
+		}
+
+		maxBytes := t.maxJSONBytes()
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = &BodyTooLargeError{Limit: maxBytes}
+			}
+			_ = t.ErrorJSON(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if opt.Scope != nil {
+			// keys hold no control characters, so the scope cannot run into the key
+			key = opt.Scope(r) + "\n" + key
+		}
+
+		created := time.Now()
+		fingerprint := idempotencyFingerprint(r, body)
+		existing, err := store.Reserve(key, &IdempotencyRecord{Fingerprint: fingerprint, CreatedAt: created})
+		switch {
+		case err != nil:
+			_ = t.ErrorJSON(w, errors.New(http.StatusText(http.StatusInternalServerError)), http.StatusInternalServerError)
+			return
+		case existing == nil:
+		case existing.Fingerprint != fingerprint:
+			_ = t.ErrorJSON(w, ErrIdempotencyKeyReused)
+			return
+		case !existing.Completed:
+			_ = t.ErrorJSON(w, ErrIdempotencyInProgress)
+			return
+		default:
+			t.replayIdempotent(w, existing)
+			return
+		}
+
+		// the key is released unless the response is stored, including if next panics
+		completed := false
+		defer func() {
+			if !completed {
+				_ = store.Release(key)
+			}
+		}()
+
+		rec := &idempotencyRecorder{ResponseWriter: w, limit: maxResponse}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if rec.status >= 500 || rec.overflow {
+			return
+		}
+
+		header := w.Header().Clone()
+		header.Del(t.requestIDHeader())
+		stored, err := t.identityBody(header, rec.body.Bytes(), maxResponse)
+		if err != nil {
+			return
+		}
+		completed = store.Complete(key, &IdempotencyRecord{
+			Fingerprint: fingerprint,
+			Completed:   true,
+			StatusCode:  rec.status,
+			Header:      header,
+			Body:        stored,
+			CreatedAt:   created,
+		}) == nil
+	})
+}
+
+// replayIdempotent writes a stored response. The body is stored without a Content-Encoding, and is
+// compressed again for the request being answered, as its client may accept other encodings.
+func (t *Tools) replayIdempotent(w http.ResponseWriter, rec *IdempotencyRecord) {
+	h := w.Header()
+	for name, values := range rec.Header {
+		h[name] = values
+	}
+	// compressBody adds Accept-Encoding back if it applies to this response
+	if vary := h.Values("Vary"); len(vary) > 0 {
+		h.Del("Vary")
+		for _, v := range vary {
+			if !strings.EqualFold(v, "Accept-Encoding") {
+				h.Add("Vary", v)
+			}
+		}
+	}
+	h.Set(idempotentReplayedHeader, "true")
+
+	body, err := t.compressBody(w, rec.Body)
+	if err != nil {
+		body = rec.Body
+	}
+	w.WriteHeader(rec.StatusCode)
+	_, _ = w.Write(body)
+}
+
+// identityBody undoes the Content-Encoding that compressBody applied to a response, removing it
+// from header, so that the response can be stored for clients that do not accept the encoding.
+// It refuses to return more than limit bytes.
+func (t *Tools) identityBody(header http.Header, body []byte, limit int64) ([]byte, error) {
+	name := header.Get("Content-Encoding")
+	if name == "" {
+		return body, nil
+	}
+
+	var enc ContentEncoding
+	for _, e := range t.contentEncodings() {
+		if strings.EqualFold(e.Name(), name) {
+			enc = e
+			break
+		}
+	}
+	if enc == nil {
+		return nil, &UnsupportedEncodingError{Encoding: name}
+	}
+
+	zr, err := enc.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, &BodyTooLargeError{Limit: limit}
+	}
+
+	header.Del("Content-Encoding")
+	if etag := header.Get("ETag"); etag != "" {
+		tag, weak := t.opaqueTag(etag)
+		if weak {
+			tag = "W/" + tag
+		}
+		header.Set("ETag", tag)
+	}
+
+	return out, nil
+}
+
+// validIdempotencyKey reports whether key is short printable ASCII.
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKey {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// idempotencyFingerprint identifies a request by its method, URL and body.
+func idempotencyFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyRecorder passes a response through while keeping a copy of its status and body. It
+// stops keeping the body, and sets overflow, once the body is larger than limit.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (rec *idempotencyRecorder) WriteHeader(code int) {
+	if rec.status == 0 && code >= 200 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if !rec.overflow {
+		if int64(rec.body.Len()+len(b)) > rec.limit {
+			rec.overflow = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(b)
+		}
+	}
+
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter { return rec.ResponseWriter }
+
+// MemoryIdempotencyStore is an IdempotencyStore that keeps records in memory.
+type MemoryIdempotencyStore struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	records   map[string]*IdempotencyRecord
+	lastPrune time.Time
+}
+
+// NewMemoryIdempotencyStore returns an empty MemoryIdempotencyStore that forgets records ttl after
+// they are created. A ttl of 0 means 24 hours.
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+
+	return &MemoryIdempotencyStore{ttl: ttl, records: make(map[string]*IdempotencyRecord)}
+}
+
+// Reserve implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Reserve(key string, rec *IdempotencyRecord) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// expired records are removed at most once a minute
+	now := time.Now()
+	if now.Sub(s.lastPrune) > time.Minute {
+		for k, existing := range s.records {
+			if now.Sub(existing.CreatedAt) > s.ttl {
+				delete(s.records, k)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	if existing, ok := s.records[key]; ok && now.Sub(existing.CreatedAt) <= s.ttl {
+		return existing, nil
+	}
+	s.records[key] = rec
+
+	return nil, nil
+}
+
+// Complete implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Complete(key string, rec *IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = rec
+
+	return nil
+}
+
+// Release implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
+
+// FileIdempotencyStore is an IdempotencyStore that keeps each record as a JSON file in a
+// directory, so that records survive restarts and can be shared by processes on the same host.
+type FileIdempotencyStore struct {
+	dir string
+	ttl time.Duration
+	mu  sync.Mutex
+}
+
+// NewFileIdempotencyStore returns a FileIdempotencyStore keeping its records in dir, which is
+// created if it does not exist. Records are ignored ttl after they are created, and removed by
+// Prune; a ttl of 0 means 24 hours.
+func NewFileIdempotencyStore(dir string, ttl time.Duration) (*FileIdempotencyStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+
+	return &FileIdempotencyStore{dir: dir, ttl: ttl}, nil
+}
+
+// Reserve implements IdempotencyStore. The record is written to a temporary file and then linked
+// into place, which fails if another request, in this process or another, reserved key first.
+func (s *FileIdempotencyStore) Reserve(key string, rec *IdempotencyRecord) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := s.writeTemp(rec)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	path := s.path(key)
+	for range 2 {
+		err := os.Link(tmp, path)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		existing, err := s.read(path)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return existing, nil
+		}
+		// the existing record has expired or was removed meanwhile, so try again
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return nil, errors.New("could not reserve idempotency key")
+}
+
+// Complete implements IdempotencyStore.
+func (s *FileIdempotencyStore) Complete(key string, rec *IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp, err := s.writeTemp(rec)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path(key)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// Release implements IdempotencyStore.
+func (s *FileIdempotencyStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Prune removes the records that have expired.
+func (s *FileIdempotencyStore) Prune() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		rec, err := s.read(path)
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// path returns the file for key. Keys are hashed, since they may hold any printable character.
+func (s *FileIdempotencyStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// read returns the record in path, or nil if there is none or it has expired.
+func (s *FileIdempotencyStore) read(path string) (*IdempotencyRecord, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rec IdempotencyRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	if time.Since(rec.CreatedAt) > s.ttl {
+		return nil, nil
+	}
+
+	return &rec, nil
+}
+
+// writeTemp writes rec to a new temporary file in the store's directory and returns its path.
+func (s *FileIdempotencyStore) writeTemp(rec *IdempotencyRecord) (string, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
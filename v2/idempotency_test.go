@@ -0,0 +1,310 @@
+package toolkit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestIdempotentHandler(t *Tools, store IdempotencyStore, calls *int) http.Handler {
+	return t.Middleware(t.Idempotent(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		var payload map[string]any
+		if err := t.ReadJSON(w, r, &payload); err != nil {
+			_ = t.ErrorJSON(w, err)
+			return
+		}
+		if payload["fail"] == true {
+			_ = t.ErrorJSON(w, io.ErrUnexpectedEOF, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Location", "/orders/1")
+		_ = t.WriteJSON(w, http.StatusCreated, map[string]any{"id": *calls, "echo": payload})
+	})))
+}
+
+var idempotentTests = []struct {
+	name           string
+	method         string
+	key            string
+	body           string
+	expectedStatus int
+	expectedBody   string
+	replayed       bool
+	expectedCalls  int
+}{
+	{name: "first", method: "POST", key: "k1", body: `{"a":1}`, expectedStatus: http.StatusCreated, expectedBody: `{"echo":{"a":1},"id":1}`, expectedCalls: 1},
+	{name: "replay", method: "POST", key: "k1", body: `{"a":1}`, expectedStatus: http.StatusCreated, expectedBody: `{"echo":{"a":1},"id":1}`, replayed: true, expectedCalls: 1},
+	{name: "different body", method: "POST", key: "k1", body: `{"a":2}`, expectedStatus: http.StatusUnprocessableEntity, expectedBody: `{"error":true,"message":"idempotency key was already used for a different request"}`, expectedCalls: 1},
+	{name: "other key", method: "POST", key: "k2", body: `{"a":1}`, expectedStatus: http.StatusCreated, expectedBody: `{"echo":{"a":1},"id":2}`, expectedCalls: 2},
+	{name: "no key", method: "POST", body: `{"a":1}`, expectedStatus: http.StatusCreated, expectedBody: `{"echo":{"a":1},"id":3}`, expectedCalls: 3},
+	{name: "put is not tracked", method: "PUT", key: "k1", body: `{"a":1}`, expectedStatus: http.StatusCreated, expectedBody: `{"echo":{"a":1},"id":4}`, expectedCalls: 4},
+	{name: "bad key", method: "POST", key: "k\x01", body: `{}`, expectedStatus: http.StatusBadRequest, expectedBody: `{"error":true,"message":"invalid idempotency key"}`, expectedCalls: 4},
+	{name: "error response is kept", method: "POST", key: "k3", body: `{"a":`, expectedStatus: http.StatusBadRequest, expectedCalls: 5},
+	{name: "error response is replayed", method: "POST", key: "k3", body: `{"a":`, expectedStatus: http.StatusBadRequest, replayed: true, expectedCalls: 5},
+	{name: "server error is not kept", method: "POST", key: "k4", body: `{"fail":true}`, expectedStatus: http.StatusInternalServerError, expectedCalls: 6},
+	{name: "server error is retried", method: "POST", key: "k4", body: `{"fail":true}`, expectedStatus: http.StatusInternalServerError, expectedCalls: 7},
+}
+
+func TestTools_Idempotent(t *testing.T) {
+	fileStore, err := NewFileIdempotencyStore(filepath.Join(t.TempDir(), "keys"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]IdempotencyStore{
+		"memory": NewMemoryIdempotencyStore(0),
+		"file":   fileStore,
+	}
+
+	for storeName, store := range stores {
+		var testTools Tools
+		calls := 0
+		handler := newTestIdempotentHandler(&testTools, store, &calls)
+
+		for _, e := range idempotentTests {
+			t.Run(storeName+" "+e.name, func(t *testing.T) {
+				req := httptest.NewRequest(e.method, "/orders", strings.NewReader(e.body))
+				if e.key != "" {
+					req.Header.Set("Idempotency-Key", e.key)
+				}
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+
+				assert.Equal(t, e.expectedStatus, rr.Code)
+				if e.expectedBody != "" {
+					assert.JSONEq(t, e.expectedBody, rr.Body.String())
+				}
+				assert.Equal(t, e.expectedCalls, calls)
+				if e.replayed {
+					assert.Equal(t, "true", rr.Header().Get("Idempotent-Replayed"))
+				} else {
+					assert.Empty(t, rr.Header().Get("Idempotent-Replayed"))
+				}
+				if e.expectedStatus == http.StatusCreated {
+					assert.Equal(t, "/orders/1", rr.Header().Get("Location"))
+				}
+				// the request ID is always that of the current request
+				assert.NotEmpty(t, rr.Header().Get("X-Request-ID"))
+			})
+		}
+	}
+}
+
+func TestTools_Idempotent_InProgress(t *testing.T) {
+	var testTools Tools
+	store := NewMemoryIdempotencyStore(0)
+	var second *httptest.ResponseRecorder
+
+	var handler http.Handler
+	handler = testTools.Idempotent(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if second == nil {
+			second = httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+			req.Header.Set("Idempotency-Key", "k")
+			handler.ServeHTTP(second, req)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+	req.Header.Set("Idempotency-Key", "k")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, http.StatusConflict, second.Code)
+}
+
+func TestTools_Idempotent_Panic(t *testing.T) {
+	var testTools Tools
+	store := NewMemoryIdempotencyStore(0)
+	handler := testTools.Idempotent(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+	req.Header.Set("Idempotency-Key", "k")
+	assert.Panics(t, func() { handler.ServeHTTP(httptest.NewRecorder(), req) })
+
+	existing, err := store.Reserve("k", &IdempotencyRecord{CreatedAt: time.Now()})
+	assert.NoError(t, err)
+	assert.Nil(t, existing)
+}
+
+func TestIdempotencyStores_Expiry(t *testing.T) {
+	fileStore, err := NewFileIdempotencyStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]IdempotencyStore{"memory": NewMemoryIdempotencyStore(time.Hour), "file": fileStore} {
+		t.Run(name, func(t *testing.T) {
+			old := &IdempotencyRecord{Fingerprint: "a", CreatedAt: time.Now().Add(-2 * time.Hour)}
+			existing, err := store.Reserve("k", old)
+			assert.NoError(t, err)
+			assert.Nil(t, existing)
+
+			// the old record has expired, so the key is free again
+			existing, err = store.Reserve("k", &IdempotencyRecord{Fingerprint: "b", CreatedAt: time.Now()})
+			assert.NoError(t, err)
+			assert.Nil(t, existing)
+
+			existing, err = store.Reserve("k", &IdempotencyRecord{Fingerprint: "c", CreatedAt: time.Now()})
+			assert.NoError(t, err)
+			if assert.NotNil(t, existing) {
+				assert.Equal(t, "b", existing.Fingerprint)
+			}
+
+			assert.NoError(t, store.Release("k"))
+			assert.NoError(t, store.Release("k"))
+		})
+	}
+}
+
+func TestFileIdempotencyStore_Prune(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileIdempotencyStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = store.Reserve("old", &IdempotencyRecord{CreatedAt: time.Now().Add(-2 * time.Hour)})
+	_, _ = store.Reserve("new", &IdempotencyRecord{CreatedAt: time.Now()})
+	assert.NoError(t, store.Prune())
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+}
+
+func TestTools_IdempotencyKeys(t *testing.T) {
+	var keys []string
+	testTools := Tools{
+		IdempotencyKeys: true,
+		Clock:           newFakeClock(),
+		Retry:           &RetryPolicy{},
+		HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+			keys = append(keys, req.Header.Get("Idempotency-Key"))
+			status := http.StatusServiceUnavailable
+			if len(keys)%2 == 0 {
+				status = http.StatusOK
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(``)), Header: make(http.Header)}
+		}),
+	}
+
+	// the POST is retried, with the same key
+	_, statusCode, err := testTools.PushJSONToRemote("http://example.com", map[string]int{"a": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	if assert.Len(t, keys, 2) {
+		assert.Len(t, keys[0], 32)
+		assert.Equal(t, keys[0], keys[1])
+	}
+
+	// each request has its own key
+	_, _, _ = testTools.PushJSONToRemote("http://example.com", nil)
+	assert.Len(t, keys, 4)
+	assert.NotEqual(t, keys[0], keys[2])
+
+	testTools.IdempotencyKeys = false
+	_, err = testTools.PostJSON(context.Background(), "http://example.com", nil, nil, WithIdempotencyKey("given"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"given", "given"}, keys[4:])
+}
+
+func TestTools_Idempotent_Compressed(t *testing.T) {
+	testTools := Tools{Compress: true, CompressMinSize: 10}
+	calls := 0
+	handler := newTestIdempotentHandler(&testTools, NewMemoryIdempotencyStore(0), &calls)
+	expected := `{"echo":{"a":"` + strings.Repeat("x", 20) + `"},"id":1}`
+
+	send := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{"a":"`+strings.Repeat("x", 20)+`"}`))
+		req.Header.Set("Idempotency-Key", "k")
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send("gzip")
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+
+	// a client that does not accept gzip gets the body as it is
+	rr = send("")
+	assert.Equal(t, "true", rr.Header().Get("Idempotent-Replayed"))
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.JSONEq(t, expected, rr.Body.String())
+	assert.Equal(t, []string{"Accept-Encoding"}, rr.Header().Values("Vary"))
+
+	rr = send("deflate")
+	assert.Equal(t, "deflate", rr.Header().Get("Content-Encoding"))
+	body, err := DeflateEncoding.NewReader(rr.Body)
+	assert.NoError(t, err)
+	out, _ := io.ReadAll(body)
+	assert.JSONEq(t, expected, string(out))
+	assert.Equal(t, 1, calls)
+}
+
+func TestTools_Idempotent_Scope(t *testing.T) {
+	var testTools Tools
+	store := NewMemoryIdempotencyStore(0)
+	calls := 0
+	handler := testTools.Idempotent(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_ = testTools.WriteJSON(w, http.StatusOK, map[string]any{"user": r.Header.Get("X-User"), "call": calls})
+	}), IdempotencyOptions{Scope: func(r *http.Request) string { return r.Header.Get("X-User") }})
+
+	send := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "k")
+		req.Header.Set("X-User", user)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.JSONEq(t, `{"user":"alice","call":1}`, send("alice").Body.String())
+	// the same key from another caller is a different request
+	bob := send("bob")
+	assert.JSONEq(t, `{"user":"bob","call":2}`, bob.Body.String())
+	assert.Empty(t, bob.Header().Get("Idempotent-Replayed"))
+
+	alice := send("alice")
+	assert.JSONEq(t, `{"user":"alice","call":1}`, alice.Body.String())
+	assert.Equal(t, "true", alice.Header().Get("Idempotent-Replayed"))
+}
+
+func TestTools_Idempotent_MaxResponseSize(t *testing.T) {
+	var testTools Tools
+	store := NewMemoryIdempotencyStore(0)
+	calls := 0
+	handler := testTools.Idempotent(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_ = testTools.WriteJSON(w, http.StatusOK, strings.Repeat("a", 100))
+	}), IdempotencyOptions{MaxResponseSize: 64})
+
+	for i := range 2 {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "k")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		// the response is passed on in full, but not kept
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Len(t, rr.Body.String(), 102)
+		assert.Empty(t, rr.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, i+1, calls)
+	}
+}
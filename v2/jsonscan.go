@@ -0,0 +1,259 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// errScanAborted stops a pre-scan early when the body is not valid JSON. The scan then reports
+// nothing, leaving the decoder to produce its usual, more precise error.
+var errScanAborted = errors.New("json scan aborted")
+
+// jsonScanner walks the tokens of a JSON body before it is decoded, checking things that
+// encoding/json cannot, such as duplicate keys, and enforcing the structural limits on Tools.
+// A limit of zero means no limit.
+type jsonScanner struct {
+	dec          *json.Decoder
+	strict       bool
+	maxDepth     int
+	maxArrayLen  int
+	maxStringLen int
+	maxKeys      int
+}
+
+// needsJSONScan reports whether decodeJSON must pre-scan the body.
+func (t *Tools) needsJSONScan() bool {
+	return t.StrictJSON || t.MaxDepth > 0 || t.MaxArrayLen > 0 || t.MaxStringLen > 0 || t.MaxKeys > 0
+}
+
+// scanJSON pre-scans body using the StrictJSON setting and the limits configured on t.
+// typ is the type body will be decoded into. It returns the first problem found, if any.
+func (t *Tools) scanJSON(body []byte, typ reflect.Type) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	s := &jsonScanner{
+		dec:          dec,
+		strict:       t.StrictJSON,
+		maxDepth:     t.MaxDepth,
+		maxArrayLen:  t.MaxArrayLen,
+		maxStringLen: t.MaxStringLen,
+		maxKeys:      t.MaxKeys,
+	}
+	err := s.scanValue(typ, "", 1)
+	if errors.Is(err, errScanAborted) {
+		return nil
+	}
+
+	return err
+}
+
+// scanValue consumes one JSON value at the given nesting depth. typ is the Go type it will
+// be decoded into, or nil if unknown.
+func (s *jsonScanner) scanValue(typ reflect.Type, path string, depth int) error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return errScanAborted
+	}
+
+	typ = indirectType(typ)
+
+	switch v := tok.(type) {
+	case json.Delim:
+		if s.maxDepth > 0 && depth > s.maxDepth {
+			return &JSONLimitError{Err: ErrMaxDepth, Path: path, Limit: s.maxDepth}
+		}
+		if v == '{' {
+			return s.scanObject(typ, path, depth)
+		}
+		return s.scanArray(typ, path, depth)
+	case string:
+		if s.maxStringLen > 0 && len(v) > s.maxStringLen {
+			return &JSONLimitError{Err: ErrMaxStringLen, Path: path, Limit: s.maxStringLen}
+		}
+	}
+
+	return nil
+}
+
+// scanArray consumes the elements of an array whose opening bracket has already been read.
+func (s *jsonScanner) scanArray(typ reflect.Type, path string, depth int) error {
+	var elem reflect.Type
+	if typ != nil && (typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) {
+		elem = typ.Elem()
+	}
+
+	for i := 0; s.dec.More(); i++ {
+		if s.maxArrayLen > 0 && i >= s.maxArrayLen {
+			return &JSONLimitError{Err: ErrMaxArrayLen, Path: path, Limit: s.maxArrayLen}
+		}
+		if err := s.scanValue(elem, fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.dec.Token(); err != nil {
+		return errScanAborted
+	}
+
+	return nil
+}
+
+// scanObject consumes the members of an object whose opening brace has already been read.
+func (s *jsonScanner) scanObject(typ reflect.Type, path string, depth int) error {
+	seen := make(map[string]bool)
+
+	for keys := 0; s.dec.More(); keys++ {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return errScanAborted
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return errScanAborted
+		}
+
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+
+		if s.maxKeys > 0 && keys >= s.maxKeys {
+			return &JSONLimitError{Err: ErrMaxKeys, Path: path, Limit: s.maxKeys}
+		}
+		if s.maxStringLen > 0 && len(key) > s.maxStringLen {
+			return &JSONLimitError{Err: ErrMaxStringLen, Path: keyPath, Limit: s.maxStringLen}
+		}
+
+		if s.strict && seen[key] {
+			return &DuplicateKeyError{Path: keyPath}
+		}
+		seen[key] = true
+
+		child, field, exact := memberType(typ, key)
+		if s.strict && field != "" && !exact {
+			return &KeyCaseError{Path: keyPath, Field: field}
+		}
+
+		if err := s.scanValue(child, keyPath, depth+1); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.dec.Token(); err != nil {
+		return errScanAborted
+	}
+
+	return nil
+}
+
+// memberType returns the type of the member key of typ. For structs, field is the JSON name
+// of the matching field, if any, and exact reports whether key matches it exactly. Like
+// encoding/json, an exact match is preferred, and otherwise the first field in declaration
+// order whose name matches without regard to case is used.
+func memberType(typ reflect.Type, key string) (child reflect.Type, field string, exact bool) {
+	if typ == nil {
+		return nil, "", true
+	}
+
+	switch typ.Kind() {
+	case reflect.Map:
+		return typ.Elem(), "", true
+	case reflect.Struct:
+		fields := jsonFields(typ)
+		if i, ok := fields.index[key]; ok {
+			return fields.list[i].typ, key, true
+		}
+		for _, f := range fields.list {
+			if strings.EqualFold(f.name, key) {
+				return f.typ, f.name, false
+			}
+		}
+	}
+
+	return nil, "", true
+}
+
+// jsonField is a field of a struct as encoding/json sees it.
+type jsonField struct {
+	name string
+	typ  reflect.Type
+}
+
+// jsonFieldList holds the fields of a struct type in declaration order, indexed by name.
+type jsonFieldList struct {
+	list  []jsonField
+	index map[string]int
+}
+
+// jsonFieldCache maps struct types to their *jsonFieldList.
+var jsonFieldCache sync.Map
+
+// jsonFields returns the JSON fields of the struct type typ, including those promoted from
+// embedded structs. The result is computed once per type.
+func jsonFields(typ reflect.Type) *jsonFieldList {
+	if fields, ok := jsonFieldCache.Load(typ); ok {
+		return fields.(*jsonFieldList)
+	}
+
+	fields, _ := jsonFieldCache.LoadOrStore(typ, buildJSONFields(typ))
+	return fields.(*jsonFieldList)
+}
+
+// buildJSONFields lists the JSON fields of typ. A field declared directly on typ takes
+// precedence over a promoted field with the same name.
+func buildJSONFields(typ reflect.Type) *jsonFieldList {
+	fields := &jsonFieldList{index: make(map[string]int)}
+	add := func(f jsonField, promoted bool) {
+		if i, ok := fields.index[f.name]; ok {
+			if !promoted {
+				fields.list[i].typ = f.typ
+			}
+			return
+		}
+		fields.index[f.name] = len(fields.list)
+		fields.list = append(fields.list, f)
+	}
+
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		name, skip := jsonFieldName(sf)
+		if skip {
+			continue
+		}
+
+		if sf.Anonymous && sf.Tag.Get("json") == "" {
+			if ft := indirectType(sf.Type); ft.Kind() == reflect.Struct {
+				for _, f := range jsonFields(ft).list {
+					add(f, true)
+				}
+				continue
+			}
+		}
+
+		if !sf.IsExported() {
+			continue
+		}
+
+		add(jsonField{name: name, typ: sf.Type}, false)
+	}
+
+	return fields
+}
+
+// indirectType dereferences pointer types. It returns nil for nil and for interface types.
+func indirectType(typ reflect.Type) reflect.Type {
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ != nil && typ.Kind() == reflect.Interface {
+		return nil
+	}
+
+	return typ
+}
@@ -0,0 +1,229 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type strictItem struct {
+	Amount int `json:"amount"`
+}
+
+type strictBase struct {
+	ID string `json:"id"`
+}
+
+type strictOrder struct {
+	strictBase
+	Items []strictItem          `json:"items"`
+	Meta  map[string]any        `json:"meta"`
+	Extra map[string]strictItem `json:"extra"`
+}
+
+var strictTests = []struct {
+	name         string
+	json         string
+	sentinel     error
+	expectedPath string
+}{
+	{
+		name: "valid",
+		json: `{"id":"1","items":[{"amount":1}],"meta":{"a":1,"A":2}}`,
+	},
+	{
+		name:         "duplicate top level key",
+		json:         `{"id":"1","id":"2"}`,
+		sentinel:     ErrDuplicateKey,
+		expectedPath: "id",
+	},
+	{
+		name:         "duplicate nested key",
+		json:         `{"items":[{"amount":1},{"amount":1,"amount":9999}]}`,
+		sentinel:     ErrDuplicateKey,
+		expectedPath: "items[1].amount",
+	},
+	{
+		name:         "duplicate map key",
+		json:         `{"meta":{"a":{"b":1,"b":2}}}`,
+		sentinel:     ErrDuplicateKey,
+		expectedPath: "meta.a.b",
+	},
+	{
+		name:         "wrong case",
+		json:         `{"items":[{"AMOUNT":1}]}`,
+		sentinel:     ErrKeyCase,
+		expectedPath: "items[0].AMOUNT",
+	},
+	{
+		name:         "wrong case of embedded field",
+		json:         `{"Id":"1"}`,
+		sentinel:     ErrKeyCase,
+		expectedPath: "Id",
+	},
+	{
+		name:         "wrong case below map",
+		json:         `{"extra":{"x":{"Amount":1}}}`,
+		sentinel:     ErrKeyCase,
+		expectedPath: "extra.x.Amount",
+	},
+	{
+		name:     "syntax errors are left to the decoder",
+		json:     `{"id":"1",}`,
+		sentinel: ErrBadJSON,
+	},
+}
+
+func TestTools_ReadJSONStrict(t *testing.T) {
+	testTools := Tools{StrictJSON: true}
+
+	for _, e := range strictTests {
+		t.Run(e.name, func(t *testing.T) {
+			var order strictOrder
+			req, _ := http.NewRequest("POST", "/", bytes.NewReader([]byte(e.json)))
+			err := testTools.ReadJSON(httptest.NewRecorder(), req, &order)
+
+			if e.sentinel == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, e.sentinel)
+
+			var dup *DuplicateKeyError
+			var keyCase *KeyCaseError
+			switch {
+			case errors.As(err, &dup):
+				assert.Equal(t, e.expectedPath, dup.Path)
+			case errors.As(err, &keyCase):
+				assert.Equal(t, e.expectedPath, keyCase.Path)
+			}
+		})
+	}
+}
+
+func TestTools_ReadJSONNotStrict(t *testing.T) {
+	var testTools Tools
+
+	var item strictItem
+	req, _ := http.NewRequest("POST", "/", bytes.NewReader([]byte(`{"amount":1,"AMOUNT":9999}`)))
+	err := testTools.ReadJSON(httptest.NewRecorder(), req, &item)
+	assert.NoError(t, err)
+	assert.Equal(t, 9999, item.Amount)
+}
+
+var limitTests = []struct {
+	name         string
+	json         string
+	tools        Tools
+	sentinel     error
+	expectedPath string
+}{
+	{
+		name:  "within limits",
+		json:  `{"a":[[1,2],[3]],"b":"abc"}`,
+		tools: Tools{MaxDepth: 3, MaxArrayLen: 2, MaxStringLen: 3, MaxKeys: 2},
+	},
+	{
+		name:         "too deep",
+		json:         `{"a":[[[1]]]}`,
+		tools:        Tools{MaxDepth: 3},
+		sentinel:     ErrMaxDepth,
+		expectedPath: "a[0][0]",
+	},
+	{
+		name:         "array too long",
+		json:         `{"a":[1,2,3]}`,
+		tools:        Tools{MaxArrayLen: 2},
+		sentinel:     ErrMaxArrayLen,
+		expectedPath: "a",
+	},
+	{
+		name:         "string too long",
+		json:         `{"a":{"b":"abcd"}}`,
+		tools:        Tools{MaxStringLen: 3},
+		sentinel:     ErrMaxStringLen,
+		expectedPath: "a.b",
+	},
+	{
+		name:         "key too long",
+		json:         `{"abcd":1}`,
+		tools:        Tools{MaxStringLen: 3},
+		sentinel:     ErrMaxStringLen,
+		expectedPath: "abcd",
+	},
+	{
+		name:         "too many keys",
+		json:         `{"a":{"x":1,"y":2,"z":3}}`,
+		tools:        Tools{MaxKeys: 2},
+		sentinel:     ErrMaxKeys,
+		expectedPath: "a",
+	},
+	{
+		name:         "duplicate keys still count",
+		json:         `{"x":1,"x":2,"x":3}`,
+		tools:        Tools{MaxKeys: 2},
+		sentinel:     ErrMaxKeys,
+		expectedPath: "",
+	},
+}
+
+func TestTools_ReadJSONLimits(t *testing.T) {
+	for _, e := range limitTests {
+		t.Run(e.name, func(t *testing.T) {
+			testTools := e.tools
+			testTools.AllowUnknownFields = true
+
+			var decoded map[string]any
+			req, _ := http.NewRequest("POST", "/", bytes.NewReader([]byte(e.json)))
+			err := testTools.ReadJSON(httptest.NewRecorder(), req, &decoded)
+
+			if e.sentinel == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, e.sentinel)
+
+			var limitErr *JSONLimitError
+			assert.True(t, errors.As(err, &limitErr))
+			assert.Equal(t, e.expectedPath, limitErr.Path)
+
+			rr := httptest.NewRecorder()
+			assert.NoError(t, testTools.ErrorJSON(rr, err))
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+		})
+	}
+}
+
+type caseFoldItem struct {
+	strictBase
+	Zeta  int    `json:"Name"`
+	Alpha string `json:"NAME"`
+}
+
+func TestMemberType(t *testing.T) {
+	typ := reflect.TypeOf(caseFoldItem{})
+
+	// among fields that differ only in case, the first one declared matches, every time
+	for range 20 {
+		child, field, exact := memberType(typ, "name")
+		assert.Equal(t, reflect.TypeOf(0), child)
+		assert.Equal(t, "Name", field)
+		assert.False(t, exact)
+	}
+
+	child, field, exact := memberType(typ, "NAME")
+	assert.Equal(t, reflect.TypeOf(""), child)
+	assert.Equal(t, "NAME", field)
+	assert.True(t, exact)
+
+	_, field, exact = memberType(typ, "id")
+	assert.Equal(t, "id", field)
+	assert.True(t, exact)
+
+	assert.Same(t, jsonFields(typ), jsonFields(typ))
+}
@@ -0,0 +1,268 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Defaults used by ReadPageRequest when DefaultPageLimit and MaxPageLimit are not set.
+const (
+	defaultPageLimit = 20
+	defaultMaxLimit  = 100
+)
+
+// PageRequest is the pagination requested by a client, from the limit, offset and cursor
+// query parameters. Offset and Cursor are never both set.
+type PageRequest struct {
+	Limit  int
+	Offset int
+	Cursor string
+}
+
+// PageMeta describes a page in the page envelope.
+type PageMeta struct {
+	Limit      int    `json:"limit"`
+	Offset     *int   `json:"offset,omitempty"`
+	Total      *int   `json:"total,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// PageLinks holds the URLs of the pages around the current one. Empty links are omitted.
+type PageLinks struct {
+	First string `json:"first,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+}
+
+// Page is the envelope for one page of a list response. When WriteJSON writes a Page,
+// it also sets the RFC 8288 Link header from its links.
+type Page[T any] struct {
+	Data  []T       `json:"data"`
+	Meta  PageMeta  `json:"meta"`
+	Links PageLinks `json:"links"`
+}
+
+// pageLinks lets WriteJSON find the links of any Page, whatever its type parameter.
+func (p Page[T]) pageLinks() PageLinks { return p.Links }
+
+type linkedPage interface {
+	pageLinks() PageLinks
+}
+
+// ReadPageRequest parses the limit, offset and cursor query parameters of r. A missing limit
+// defaults to DefaultPageLimit (20 if unset) and larger limits are reduced to MaxPageLimit
+// (100 if unset). Malformed values, or both an offset and a cursor, result in a *QueryParamError.
+func (t *Tools) ReadPageRequest(r *http.Request) (PageRequest, error) {
+	q := r.URL.Query()
+
+	req := PageRequest{Limit: defaultPageLimit}
+	if t.DefaultPageLimit > 0 {
+		req.Limit = t.DefaultPageLimit
+	}
+	maxLimit := defaultMaxLimit
+	if t.MaxPageLimit > 0 {
+		maxLimit = t.MaxPageLimit
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return PageRequest{}, &QueryParamError{Param: "limit", Value: v, Message: "must be a positive integer"}
+		}
+		req.Limit = n
+	}
+	if req.Limit > maxLimit {
+		req.Limit = maxLimit
+	}
+
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return PageRequest{}, &QueryParamError{Param: "offset", Value: v, Message: "must be a non-negative integer"}
+		}
+		req.Offset = n
+	}
+
+	req.Cursor = q.Get("cursor")
+	if req.Cursor != "" && q.Get("offset") != "" {
+		return PageRequest{}, &QueryParamError{Param: "cursor", Value: req.Cursor, Message: "cannot be combined with offset"}
+	}
+
+	return req, nil
+}
+
+// NewOffsetPage builds the envelope for a page of an offset paginated list. total is the total
+// number of items, or a negative number if it is unknown, in which case a next link is given
+// whenever the page is full.
+func NewOffsetPage[T any](r *http.Request, req PageRequest, data []T, total int) Page[T] {
+	if data == nil {
+		data = []T{}
+	}
+
+	offset := req.Offset
+	page := Page[T]{Data: data, Meta: PageMeta{Limit: req.Limit, Offset: &offset}}
+	if total >= 0 {
+		page.Meta.Total = &total
+	}
+
+	page.Links.First = pageURL(r, map[string]string{"limit": strconv.Itoa(req.Limit), "offset": "0"})
+	if req.Offset > 0 {
+		prev := max(req.Offset-req.Limit, 0)
+		page.Links.Prev = pageURL(r, map[string]string{"limit": strconv.Itoa(req.Limit), "offset": strconv.Itoa(prev)})
+	}
+
+	// there is no next page past the largest offset, and comparing without adding avoids overflow
+	hasNext := len(data) >= req.Limit && req.Offset <= math.MaxInt-req.Limit
+	if total >= 0 {
+		hasNext = req.Offset < total-req.Limit
+	}
+	if hasNext {
+		page.Links.Next = pageURL(r, map[string]string{"limit": strconv.Itoa(req.Limit), "offset": strconv.Itoa(req.Offset + req.Limit)})
+	}
+
+	return page
+}
+
+// NewCursorPage builds the envelope for a page of a cursor paginated list. next and prev are the
+// cursors of the neighbouring pages, as returned by EncodeCursor, or empty if there are none.
+func NewCursorPage[T any](r *http.Request, req PageRequest, data []T, next, prev string) Page[T] {
+	if data == nil {
+		data = []T{}
+	}
+
+	page := Page[T]{Data: data, Meta: PageMeta{Limit: req.Limit, NextCursor: next, PrevCursor: prev}}
+
+	page.Links.First = pageURL(r, map[string]string{"limit": strconv.Itoa(req.Limit), "cursor": ""})
+	if prev != "" {
+		page.Links.Prev = pageURL(r, map[string]string{"limit": strconv.Itoa(req.Limit), "cursor": prev})
+	}
+	if next != "" {
+		page.Links.Next = pageURL(r, map[string]string{"limit": strconv.Itoa(req.Limit), "cursor": next})
+	}
+
+	return page
+}
+
+// pageURL returns the path and query of r with the given query parameters replaced. Parameters
+// with an empty value are removed, and so is offset when a cursor is given and vice versa.
+func pageURL(r *http.Request, params map[string]string) string {
+	q := r.URL.Query()
+	if _, ok := params["cursor"]; ok {
+		q.Del("offset")
+	}
+	if _, ok := params["offset"]; ok {
+		q.Del("cursor")
+	}
+
+	for k, v := range params {
+		if v == "" {
+			q.Del(k)
+			continue
+		}
+		q.Set(k, v)
+	}
+
+	u := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// SetLinkHeader sets the RFC 8288 Link header to the first, prev and next links that are not empty.
+func (t *Tools) SetLinkHeader(w http.ResponseWriter, links PageLinks) {
+	var parts []string
+	for _, l := range []struct{ rel, target string }{{"first", links.First}, {"prev", links.Prev}, {"next", links.Next}} {
+		if l.target != "" {
+			parts = append(parts, fmt.Sprintf(`<%s>; rel="%s"`, l.target, l.rel))
+		}
+	}
+
+	if len(parts) > 0 {
+		w.Header().Set("Link", strings.Join(parts, ", "))
+	}
+}
+
+// EncodeCursor returns an opaque cursor holding v, encoded as JSON and signed with CursorKey so
+// that clients cannot forge or alter it.
+func (t *Tools) EncodeCursor(v any) (string, error) {
+	if len(t.CursorKey) == 0 {
+		return "", errors.New("cursor signing key is not set")
+	}
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(t.cursorMAC(payload)), nil
+}
+
+// DecodeCursor verifies a cursor made by EncodeCursor and decodes its contents into v.
+// A cursor that is malformed or was not signed with CursorKey results in ErrInvalidCursor.
+func (t *Tools) DecodeCursor(cursor string, v any) error {
+	if len(t.CursorKey) == 0 {
+		return errors.New("cursor signing key is not set")
+	}
+
+	enc := base64.RawURLEncoding
+	p, s, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return ErrInvalidCursor
+	}
+	payload, err := enc.DecodeString(p)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	sig, err := enc.DecodeString(s)
+	if err != nil || !hmac.Equal(sig, t.cursorMAC(payload)) {
+		return ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(payload, v); err != nil {
+		return ErrInvalidCursor
+	}
+
+	return nil
+}
+
+// cursorMAC signs a cursor payload with CursorKey.
+func (t *Tools) cursorMAC(payload []byte) []byte {
+	mac := hmac.New(sha256.New, t.CursorKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// QueryParamError reports a query string or form parameter with an invalid value.
+// Source is "query" or "form", and defaults to "query".
+type QueryParamError struct {
+	Source  string
+	Param   string
+	Value   string
+	Message string
+}
+
+func (e *QueryParamError) Error() string {
+	source := e.Source
+	if source == "" {
+		source = "query"
+	}
+
+	return fmt.Sprintf("%s parameter %q %s", source, e.Param, e.Message)
+}
+
+func (e *QueryParamError) Unwrap() error   { return ErrBadQuery }
+func (e *QueryParamError) HTTPStatus() int { return http.StatusBadRequest }
+
+// ProblemExtensions reports the offending parameter and value.
+func (e *QueryParamError) ProblemExtensions() map[string]any {
+	return map[string]any{"param": e.Param, "value": e.Value}
+}
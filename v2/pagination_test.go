@@ -0,0 +1,130 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var pageRequestTests = []struct {
+	name          string
+	query         string
+	expected      PageRequest
+	errorExpected bool
+}{
+	{name: "defaults", query: "", expected: PageRequest{Limit: 10}},
+	{name: "limit and offset", query: "limit=5&offset=15", expected: PageRequest{Limit: 5, Offset: 15}},
+	{name: "limit clamped", query: "limit=500", expected: PageRequest{Limit: 50}},
+	{name: "cursor", query: "cursor=abc", expected: PageRequest{Limit: 10, Cursor: "abc"}},
+	{name: "bad limit", query: "limit=zero", errorExpected: true},
+	{name: "zero limit", query: "limit=0", errorExpected: true},
+	{name: "negative offset", query: "offset=-1", errorExpected: true},
+	{name: "offset and cursor", query: "offset=1&cursor=abc", errorExpected: true},
+}
+
+func TestTools_ReadPageRequest(t *testing.T) {
+	testTools := Tools{DefaultPageLimit: 10, MaxPageLimit: 50}
+
+	for _, e := range pageRequestTests {
+		t.Run(e.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/items?"+e.query, nil)
+			pr, err := testTools.ReadPageRequest(req)
+
+			if e.errorExpected {
+				assert.ErrorIs(t, err, ErrBadQuery)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, e.expected, pr)
+		})
+	}
+}
+
+func TestTools_Cursor(t *testing.T) {
+	testTools := Tools{CursorKey: []byte("secret")}
+
+	type position struct {
+		ID int `json:"id"`
+	}
+
+	cursor, err := testTools.EncodeCursor(position{ID: 42})
+	assert.NoError(t, err)
+
+	var decoded position
+	assert.NoError(t, testTools.DecodeCursor(cursor, &decoded))
+	assert.Equal(t, 42, decoded.ID)
+
+	otherTools := Tools{CursorKey: []byte("other")}
+	assert.ErrorIs(t, otherTools.DecodeCursor(cursor, &decoded), ErrInvalidCursor)
+	assert.ErrorIs(t, testTools.DecodeCursor("not-a-cursor", &decoded), ErrInvalidCursor)
+	assert.ErrorIs(t, testTools.DecodeCursor(cursor[1:], &decoded), ErrInvalidCursor)
+
+	var noKey Tools
+	_, err = noKey.EncodeCursor(position{ID: 1})
+	assert.Error(t, err)
+}
+
+func TestTools_WriteJSONOffsetPage(t *testing.T) {
+	var testTools Tools
+
+	req, _ := http.NewRequest("GET", "/items?sort=name&limit=2&offset=2", nil)
+	pr, err := testTools.ReadPageRequest(req)
+	assert.NoError(t, err)
+
+	page := NewOffsetPage(req, pr, []string{"c", "d"}, 5)
+	assert.Equal(t, "/items?limit=2&offset=0&sort=name", page.Links.First)
+	assert.Equal(t, "/items?limit=2&offset=0&sort=name", page.Links.Prev)
+	assert.Equal(t, "/items?limit=2&offset=4&sort=name", page.Links.Next)
+
+	rr := httptest.NewRecorder()
+	assert.NoError(t, testTools.WriteJSON(rr, http.StatusOK, page))
+	assert.Equal(t,
+		`</items?limit=2&offset=0&sort=name>; rel="first", </items?limit=2&offset=0&sort=name>; rel="prev", </items?limit=2&offset=4&sort=name>; rel="next"`,
+		rr.Header().Get("Link"))
+
+	var body struct {
+		Data []string `json:"data"`
+		Meta PageMeta `json:"meta"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+	assert.Equal(t, []string{"c", "d"}, body.Data)
+	assert.Equal(t, 5, *body.Meta.Total)
+	assert.Equal(t, 2, *body.Meta.Offset)
+
+	// last page, with an unknown total
+	req, _ = http.NewRequest("GET", "/items?limit=2&offset=4", nil)
+	pr, _ = testTools.ReadPageRequest(req)
+	page = NewOffsetPage(req, pr, []string{"e"}, -1)
+	assert.Empty(t, page.Links.Next)
+	assert.Nil(t, page.Meta.Total)
+
+	// the largest offset has no next page, rather than one with an overflowed offset
+	req, _ = http.NewRequest("GET", "/items?limit=2&offset="+strconv.Itoa(math.MaxInt), nil)
+	pr, err = testTools.ReadPageRequest(req)
+	assert.NoError(t, err)
+	assert.Empty(t, NewOffsetPage(req, pr, []string{"x", "y"}, -1).Links.Next)
+	assert.Empty(t, NewOffsetPage(req, pr, []string{"x", "y"}, 10).Links.Next)
+}
+
+func TestTools_WriteJSONCursorPage(t *testing.T) {
+	testTools := Tools{CursorKey: []byte("secret")}
+
+	req, _ := http.NewRequest("GET", "/items?limit=2", nil)
+	pr, _ := testTools.ReadPageRequest(req)
+
+	next, _ := testTools.EncodeCursor(map[string]int{"after": 2})
+	page := NewCursorPage(req, pr, []int{1, 2}, next, "")
+	assert.Equal(t, "/items?limit=2", page.Links.First)
+	assert.Empty(t, page.Links.Prev)
+	assert.Equal(t, "/items?cursor="+next+"&limit=2", page.Links.Next)
+
+	rr := httptest.NewRecorder()
+	assert.NoError(t, testTools.WriteJSON(rr, http.StatusOK, &page))
+	assert.Contains(t, rr.Header().Get("Link"), `rel="next"`)
+	assert.NotContains(t, rr.Header().Get("Link"), `rel="prev"`)
+}
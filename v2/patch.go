@@ -0,0 +1,663 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Media types accepted by ReadPatch.
+const (
+	jsonPatchContentType  = "application/json-patch+json"
+	mergePatchContentType = "application/merge-patch+json"
+)
+
+// PatchOperation is a single RFC 6902 JSON Patch operation. Value is nil when the operation
+// has no "value" member, and the JSON literal null when it is explicitly null.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// PatchError reports an operation of a JSON Patch that could not be applied. Index is the
+// position of the operation in the patch and Path is the JSON Pointer it failed on.
+type PatchError struct {
+	Index   int
+	Op      string
+	Path    string
+	Message string
+	Err     error
+}
+
+func (e *PatchError) Error() string {
+	return fmt.Sprintf("patch operation %d (%s %q): %s", e.Index, e.Op, e.Path, e.Message)
+}
+
+func (e *PatchError) Unwrap() error { return e.Err }
+
+// HTTPStatus reports 409 Conflict for a failed test operation, and 422 Unprocessable Entity otherwise.
+func (e *PatchError) HTTPStatus() int {
+	if errors.Is(e.Err, ErrPatchTestFailed) {
+		return http.StatusConflict
+	}
+
+	return http.StatusUnprocessableEntity
+}
+
+// ProblemExtensions reports the failing operation and its JSON Pointer.
+func (e *PatchError) ProblemExtensions() map[string]any {
+	return map[string]any{"operation": e.Index, "op": e.Op, "path": e.Path}
+}
+
+// ReadPatch reads a PATCH request body and applies it to target, which must be a non-nil pointer
+// to the current state of the resource, such as a struct, a map or a json.RawMessage. The body
+// may be a JSON Patch (application/json-patch+json, RFC 6902), including test operations, or a
+// JSON Merge Patch (application/merge-patch+json, RFC 7396). A JSON Patch may have at most 1000
+// operations, and numbers too large to compare exactly only pass a test operation if they are
+// written the same way. Size limits and Content-Encoding are
+// handled as in ReadJSON, and the patched document is decoded back into target with ReadJSON's
+// rules, so AllowUnknownFields and ValidateJSON apply to the result. Struct fields tagged omitempty
+// are part of the document even when empty, so that they can be replaced or tested; fields that
+// JSON does not see, unexported or tagged json:"-", keep their current values. On error target is
+// unchanged.
+func (t *Tools) ReadPatch(w http.ResponseWriter, r *http.Request, target any) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != jsonPatchContentType && mediaType != mergePatchContentType {
+		return &UnsupportedMediaTypeError{ContentType: r.Header.Get("Content-Type")}
+	}
+
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("error applying patch: target must be a non-nil pointer, not %T", target)
+	}
+
+	body, err := t.readJSONBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	// the patch itself is checked for syntax and limits, but not against target's type
+	pt := *t
+	pt.AllowUnknownFields = true
+	pt.ValidateJSON = false
+
+	doc, err := patchDocument(rv)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+	if mediaType == jsonPatchContentType {
+		var ops []PatchOperation
+		if err := pt.decodeJSON(body, &ops); err != nil {
+			return err
+		}
+		patched, err = applyJSONPatch(doc, ops)
+	} else {
+		// decoded once to report syntax errors as ReadJSON does; ApplyMergePatch keeps number precision
+		var patch any
+		if err := pt.decodeJSON(body, &patch); err != nil {
+			return err
+		}
+		patched, err = ApplyMergePatch(doc, body)
+	}
+	if err != nil {
+		return err
+	}
+
+	result := reflect.New(rv.Elem().Type())
+	if err := t.decodeJSON(patched, result.Interface()); err != nil {
+		return err
+	}
+	if result.Elem().Kind() == reflect.Struct {
+		// the patched document lacks the fields JSON does not see, so they are kept from target
+		current := reflect.New(result.Elem().Type()).Elem()
+		current.Set(rv.Elem())
+		overlayJSONFields(current, result.Elem())
+		result = current.Addr()
+	}
+	rv.Elem().Set(result.Elem())
+
+	return nil
+}
+
+// patchDocument returns the JSON document a patch is applied to for the value rv points to.
+func patchDocument(rv reflect.Value) ([]byte, error) {
+	out, err := json.Marshal(rv.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := decodeGeneric(out)
+	if err != nil {
+		return nil, err
+	}
+	addOmittedFields(doc, rv)
+
+	return json.Marshal(doc)
+}
+
+// addOmittedFields adds to doc, the decoded JSON of v, the struct fields that omitempty left out,
+// with their empty values, including within nested structs, slices and maps.
+func addOmittedFields(doc any, v reflect.Value) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if encodesItself(v.Type()) {
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if m, ok := doc.(map[string]any); ok {
+			addOmittedStructFields(m, v)
+		}
+	case reflect.Slice, reflect.Array:
+		if a, ok := doc.([]any); ok && len(a) == v.Len() {
+			for i := range a {
+				addOmittedFields(a[i], v.Index(i))
+			}
+		}
+	case reflect.Map:
+		if m, ok := doc.(map[string]any); ok && v.Type().Key().Kind() == reflect.String {
+			iter := v.MapRange()
+			for iter.Next() {
+				addOmittedFields(m[iter.Key().String()], iter.Value())
+			}
+		}
+	}
+}
+
+// addOmittedStructFields adds the omitted fields of the struct v to m, its decoded JSON object.
+func addOmittedStructFields(m map[string]any, v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		fv := v.Field(i)
+		name, skip := jsonFieldName(sf)
+		if skip {
+			continue
+		}
+
+		if sf.Anonymous && sf.Tag.Get("json") == "" {
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct && !encodesItself(fv.Type()) {
+				addOmittedStructFields(m, fv)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+
+		if existing, ok := m[name]; ok {
+			addOmittedFields(existing, fv)
+			continue
+		}
+
+		_, opts, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+			continue
+		}
+		out, err := json.Marshal(fv.Interface())
+		if err != nil {
+			continue
+		}
+		empty, err := decodeGeneric(out)
+		if err != nil {
+			continue
+		}
+		if slices.Contains(strings.Split(opts, ","), "string") && fv.Kind() != reflect.String {
+			empty = string(out)
+		}
+		m[name] = empty
+	}
+}
+
+// encodesItself reports whether JSON encodes typ with its own MarshalJSON or MarshalText.
+func encodesItself(typ reflect.Type) bool {
+	p := reflect.PointerTo(typ)
+	return p.Implements(jsonMarshalerType) || p.Implements(textMarshalerType)
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// overlayJSONFields sets the fields of the struct dst that JSON encodes to those of src. Fields
+// JSON does not see, unexported or tagged json:"-", keep their values in dst, including within
+// nested structs and the structs they point to; the elements of slices and maps are replaced
+// whole. The structs dst points to are copied rather than changed.
+func overlayJSONFields(dst, src reflect.Value) {
+	for i := 0; i < dst.NumField(); i++ {
+		f := dst.Type().Field(i)
+		if f.Tag.Get("json") == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+
+		d, s := dst.Field(i), src.Field(i)
+		switch {
+		case f.Type.Kind() == reflect.Struct && !decodesItself(f.Type):
+			overlayJSONFields(d, s)
+		case !d.CanSet():
+			// an unexported embedded pointer, which JSON cannot set either
+		case f.Type.Kind() == reflect.Pointer && f.Type.Elem().Kind() == reflect.Struct &&
+			!decodesItself(f.Type.Elem()) && !d.IsNil() && !s.IsNil():
+			p := reflect.New(f.Type.Elem())
+			p.Elem().Set(d.Elem())
+			overlayJSONFields(p.Elem(), s.Elem())
+			d.Set(p)
+		default:
+			d.Set(s)
+		}
+	}
+}
+
+// decodesItself reports whether JSON decodes typ with its own UnmarshalJSON or UnmarshalText,
+// such as time.Time, rather than field by field.
+func decodesItself(typ reflect.Type) bool {
+	p := reflect.PointerTo(typ)
+	return p.Implements(jsonUnmarshalerType) || p.Implements(textUnmarshalerType)
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch to the JSON document doc and returns the result.
+func ApplyJSONPatch(doc, patch []byte) ([]byte, error) {
+	var ops []PatchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, err
+	}
+
+	return applyJSONPatch(doc, ops)
+}
+
+// ApplyMergePatch applies an RFC 7396 JSON Merge Patch to the JSON document doc and returns the result.
+func ApplyMergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decodeGeneric(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeGeneric(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergePatch(target, p))
+}
+
+// mergePatch implements the MergePatch algorithm of RFC 7396 on decoded JSON values.
+func mergePatch(target, patch any) any {
+	pm, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	tm, ok := target.(map[string]any)
+	if !ok {
+		tm = make(map[string]any)
+	}
+
+	for k, v := range pm {
+		if v == nil {
+			delete(tm, k)
+			continue
+		}
+		tm[k] = mergePatch(tm[k], v)
+	}
+
+	return tm
+}
+
+// maxPatchOperations is the most operations a JSON Patch may hold, as each test operation may
+// compare numbers exactly.
+const maxPatchOperations = 1000
+
+// applyJSONPatch applies ops, in order, to doc.
+func applyJSONPatch(doc []byte, ops []PatchOperation) ([]byte, error) {
+	if len(ops) > maxPatchOperations {
+		op := ops[maxPatchOperations]
+		return nil, &PatchError{Index: maxPatchOperations, Op: op.Op, Path: op.Path,
+			Message: fmt.Sprintf("a patch may have at most %d operations", maxPatchOperations), Err: ErrInvalidPatch}
+	}
+
+	root, err := decodeGeneric(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		root, err = applyOperation(root, op)
+		if err != nil {
+			var pe *PatchError
+			if errors.As(err, &pe) {
+				pe.Index, pe.Op = i, op.Op
+				if pe.Path == "" {
+					pe.Path = op.Path
+				}
+			}
+			return nil, err
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+// applyOperation applies a single operation to root and returns the new root.
+func applyOperation(root any, op PatchOperation) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, invalidPatch(op.Path, err.Error())
+	}
+
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, invalidPatch(op.Path, `missing "value" member`)
+		}
+		return decodeGeneric(op.Value)
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return addValue(root, path, v, op.Path)
+	case "remove":
+		root, _, err := removeValue(root, path, op.Path)
+		return root, err
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := getValue(root, path, op.Path); err != nil {
+			return nil, err
+		}
+		root, _, err := removeValue(root, path, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(root, path, v, op.Path)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, invalidPatch(op.From, err.Error())
+		}
+		v, err := getValue(root, from, op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, invalidPatch(op.Path, "cannot move a value into one of its own children")
+			}
+			if root, _, err = removeValue(root, from, op.From); err != nil {
+				return nil, err
+			}
+		} else {
+			v = deepCopy(v)
+		}
+		return addValue(root, path, v, op.Path)
+	case "test":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		current, err := getValue(root, path, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, v) {
+			return nil, &PatchError{Path: op.Path, Message: "test failed: value does not match", Err: ErrPatchTestFailed}
+		}
+		return root, nil
+	default:
+		return nil, invalidPatch(op.Path, fmt.Sprintf("unknown operation %q", op.Op))
+	}
+}
+
+// invalidPatch returns a *PatchError wrapping ErrInvalidPatch.
+func invalidPatch(path, msg string) error {
+	return &PatchError{Path: path, Message: msg, Err: ErrInvalidPatch}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, tok := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token. With allowEnd, "-" refers to the position after the last element.
+func arrayIndex(tok string, length int, allowEnd bool) (int, bool) {
+	if allowEnd && tok == "-" {
+		return length, true
+	}
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') {
+		return 0, false
+	}
+
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 {
+		return 0, false
+	}
+
+	limit := length - 1
+	if allowEnd {
+		limit = length
+	}
+
+	return i, i <= limit
+}
+
+// getValue returns the value at path.
+func getValue(node any, path []string, pointer string) (any, error) {
+	for _, tok := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			v, ok := n[tok]
+			if !ok {
+				return nil, invalidPatch(pointer, "path does not exist")
+			}
+			node = v
+		case []any:
+			i, ok := arrayIndex(tok, len(n), false)
+			if !ok {
+				return nil, invalidPatch(pointer, "array index out of range")
+			}
+			node = n[i]
+		default:
+			return nil, invalidPatch(pointer, "path does not exist")
+		}
+	}
+
+	return node, nil
+}
+
+// addValue sets the value at path, inserting into arrays, and returns the new root.
+func addValue(root any, path []string, value any, pointer string) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := getValue(root, path[:len(path)-1], pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		p[last] = value
+		return root, nil
+	case []any:
+		i, ok := arrayIndex(last, len(p), true)
+		if !ok {
+			return nil, invalidPatch(pointer, "array index out of range")
+		}
+		p = append(p, nil)
+		copy(p[i+1:], p[i:])
+		p[i] = value
+		return replaceContainer(root, path[:len(path)-1], p), nil
+	default:
+		return nil, invalidPatch(pointer, "parent is not an object or array")
+	}
+}
+
+// removeValue deletes the value at path and returns the new root and the removed value.
+func removeValue(root any, path []string, pointer string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, root, nil
+	}
+
+	parent, err := getValue(root, path[:len(path)-1], pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		v, ok := p[last]
+		if !ok {
+			return nil, nil, invalidPatch(pointer, "path does not exist")
+		}
+		delete(p, last)
+		return root, v, nil
+	case []any:
+		i, ok := arrayIndex(last, len(p), false)
+		if !ok {
+			return nil, nil, invalidPatch(pointer, "array index out of range")
+		}
+		v := p[i]
+		p = append(p[:i:i], p[i+1:]...)
+		return replaceContainer(root, path[:len(path)-1], p), v, nil
+	default:
+		return nil, nil, invalidPatch(pointer, "path does not exist")
+	}
+}
+
+// replaceContainer stores a resized array back at path, since appending may have moved it.
+func replaceContainer(root any, path []string, value []any) any {
+	if len(path) == 0 {
+		return value
+	}
+
+	parent, _ := getValue(root, path[:len(path)-1], "")
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]any:
+		p[last] = value
+	case []any:
+		i, _ := arrayIndex(last, len(p), false)
+		p[i] = value
+	}
+
+	return root
+}
+
+// decodeGeneric decodes JSON into maps, slices and json.Number values.
+func decodeGeneric(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// deepCopy copies a decoded JSON value, so that copy operations do not alias.
+func deepCopy(v any) any {
+	switch n := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(n))
+		for k, val := range n {
+			m[k] = deepCopy(val)
+		}
+		return m
+	case []any:
+		s := make([]any, len(n))
+		for i, val := range n {
+			s[i] = deepCopy(val)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+// jsonEqual compares decoded JSON values as RFC 6902's test operation requires, treating
+// numbers as equal when their values are equal. Numbers too large to compare exactly are only
+// equal to the same text.
+func jsonEqual(a, b any) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		c, ok := compareNumbers(x.String(), y.String())
+		return ok && c == 0
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
@@ -0,0 +1,320 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var jsonPatchTests = []struct {
+	name          string
+	doc           string
+	patch         string
+	expected      string
+	errorExpected error
+	expectedPath  string
+}{
+	{
+		name:     "add member",
+		doc:      `{"a":1}`,
+		patch:    `[{"op":"add","path":"/b","value":null}]`,
+		expected: `{"a":1,"b":null}`,
+	},
+	{
+		name:     "add to array",
+		doc:      `{"a":[1,3]}`,
+		patch:    `[{"op":"add","path":"/a/1","value":2},{"op":"add","path":"/a/-","value":4}]`,
+		expected: `{"a":[1,2,3,4]}`,
+	},
+	{
+		name:     "remove",
+		doc:      `{"a":{"b":1,"c":2},"d":[1,2,3]}`,
+		patch:    `[{"op":"remove","path":"/a/b"},{"op":"remove","path":"/d/0"}]`,
+		expected: `{"a":{"c":2},"d":[2,3]}`,
+	},
+	{
+		name:     "replace",
+		doc:      `{"a":{"b":1}}`,
+		patch:    `[{"op":"replace","path":"/a/b","value":[1,2]}]`,
+		expected: `{"a":{"b":[1,2]}}`,
+	},
+	{
+		name:     "move and copy",
+		doc:      `{"a":{"b":1},"c":[]}`,
+		patch:    `[{"op":"copy","from":"/a/b","path":"/c/0"},{"op":"move","from":"/a","path":"/e"}]`,
+		expected: `{"c":[1],"e":{"b":1}}`,
+	},
+	{
+		name:     "escaped pointer",
+		doc:      `{"a/b":1,"m~n":2}`,
+		patch:    `[{"op":"replace","path":"/a~1b","value":3},{"op":"remove","path":"/m~0n"}]`,
+		expected: `{"a/b":3}`,
+	},
+	{
+		name:     "test passes",
+		doc:      `{"a":{"b":1.0}}`,
+		patch:    `[{"op":"test","path":"/a","value":{"b":1}},{"op":"add","path":"/c","value":true}]`,
+		expected: `{"a":{"b":1.0},"c":true}`,
+	},
+	{
+		name:          "test fails",
+		doc:           `{"a":[1,2]}`,
+		patch:         `[{"op":"add","path":"/b","value":1},{"op":"test","path":"/a/1","value":3}]`,
+		errorExpected: ErrPatchTestFailed,
+		expectedPath:  "/a/1",
+	},
+	{
+		name:          "replace missing member",
+		doc:           `{"a":1}`,
+		patch:         `[{"op":"replace","path":"/b/c","value":1}]`,
+		errorExpected: ErrInvalidPatch,
+		expectedPath:  "/b/c",
+	},
+	{
+		name:          "index out of range",
+		doc:           `{"a":[1]}`,
+		patch:         `[{"op":"add","path":"/a/5","value":1}]`,
+		errorExpected: ErrInvalidPatch,
+		expectedPath:  "/a/5",
+	},
+	{
+		name:          "missing value",
+		doc:           `{"a":1}`,
+		patch:         `[{"op":"add","path":"/b"}]`,
+		errorExpected: ErrInvalidPatch,
+		expectedPath:  "/b",
+	},
+	{
+		name:          "unknown op",
+		doc:           `{"a":1}`,
+		patch:         `[{"op":"frobnicate","path":"/a"}]`,
+		errorExpected: ErrInvalidPatch,
+		expectedPath:  "/a",
+	},
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	for _, e := range jsonPatchTests {
+		t.Run(e.name, func(t *testing.T) {
+			out, err := ApplyJSONPatch([]byte(e.doc), []byte(e.patch))
+
+			if e.errorExpected != nil {
+				assert.ErrorIs(t, err, e.errorExpected)
+
+				var pe *PatchError
+				assert.True(t, errors.As(err, &pe))
+				assert.Equal(t, e.expectedPath, pe.Path)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.JSONEq(t, e.expected, string(out))
+		})
+	}
+}
+
+func TestApplyJSONPatch_Limits(t *testing.T) {
+	// a test operation on a number too large to compare exactly is answered quickly
+	ops := strings.TrimSuffix(strings.Repeat(`{"op":"test","path":"/a","value":2e999999},`, 500), ",")
+	start := time.Now()
+	_, err := ApplyJSONPatch([]byte(`{"a":1e999999}`), []byte("["+ops+"]"))
+	assert.ErrorIs(t, err, ErrPatchTestFailed)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = ApplyJSONPatch([]byte(`{"a":1e999999}`), []byte(`[{"op":"test","path":"/a","value":1e999999}]`))
+	assert.NoError(t, err)
+
+	ops = strings.TrimSuffix(strings.Repeat(`{"op":"test","path":"/a","value":1},`, maxPatchOperations+1), ",")
+	_, err = ApplyJSONPatch([]byte(`{"a":1}`), []byte("["+ops+"]"))
+	assert.ErrorIs(t, err, ErrInvalidPatch)
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	doc := `{"title":"Goodbye!","author":{"givenName":"John","familyName":"Doe"},"tags":["example","sample"],"content":"This will be unchanged"}`
+	patch := `{"title":"Hello!","phoneNumber":"+01-123-456-7890","author":{"familyName":null},"tags":["example"]}`
+	expected := `{"title":"Hello!","author":{"givenName":"John"},"tags":["example"],"content":"This will be unchanged","phoneNumber":"+01-123-456-7890"}`
+
+	out, err := ApplyMergePatch([]byte(doc), []byte(patch))
+	assert.NoError(t, err)
+	assert.JSONEq(t, expected, string(out))
+}
+
+type patchWidget struct {
+	Name  string   `json:"name" validate:"required"`
+	Color *string  `json:"color,omitempty"`
+	Tags  []string `json:"tags"`
+}
+
+func TestTools_ReadPatch(t *testing.T) {
+	testTools := Tools{ValidateJSON: true}
+	red := "red"
+
+	var readPatchTests = []struct {
+		name          string
+		contentType   string
+		body          string
+		expected      patchWidget
+		errorExpected error
+		status        int
+	}{
+		{
+			name:        "merge patch removes with null",
+			contentType: "application/merge-patch+json",
+			body:        `{"color":null,"tags":["a"]}`,
+			expected:    patchWidget{Name: "widget", Tags: []string{"a"}},
+		},
+		{
+			name:        "json patch",
+			contentType: "application/json-patch+json; charset=utf-8",
+			body:        `[{"op":"test","path":"/color","value":"red"},{"op":"add","path":"/tags/-","value":"z"}]`,
+			expected:    patchWidget{Name: "widget", Color: &red, Tags: []string{"x", "z"}},
+		},
+		{
+			name:          "failed test operation",
+			contentType:   "application/json-patch+json",
+			body:          `[{"op":"test","path":"/color","value":"blue"}]`,
+			errorExpected: ErrPatchTestFailed,
+			status:        http.StatusConflict,
+		},
+		{
+			name:          "result fails validation",
+			contentType:   "application/merge-patch+json",
+			body:          `{"name":null}`,
+			errorExpected: ValidationErrors{},
+			status:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:          "result has unknown field",
+			contentType:   "application/merge-patch+json",
+			body:          `{"size":3}`,
+			errorExpected: ErrUnknownField,
+			status:        http.StatusBadRequest,
+		},
+		{
+			name:          "bad patch syntax",
+			contentType:   "application/json-patch+json",
+			body:          `[{"op":"add",}]`,
+			errorExpected: ErrBadJSON,
+			status:        http.StatusBadRequest,
+		},
+		{
+			name:          "wrong content type",
+			contentType:   "application/json",
+			body:          `{}`,
+			errorExpected: ErrUnsupportedMediaType,
+			status:        http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, e := range readPatchTests {
+		t.Run(e.name, func(t *testing.T) {
+			widget := patchWidget{Name: "widget", Color: &red, Tags: []string{"x"}}
+			original, _ := json.Marshal(widget)
+
+			req, _ := http.NewRequest("PATCH", "/", strings.NewReader(e.body))
+			req.Header.Set("Content-Type", e.contentType)
+
+			err := testTools.ReadPatch(httptest.NewRecorder(), req, &widget)
+
+			if e.errorExpected != nil {
+				if _, ok := e.errorExpected.(ValidationErrors); ok {
+					var ve ValidationErrors
+					assert.True(t, errors.As(err, &ve))
+				} else {
+					assert.ErrorIs(t, err, e.errorExpected)
+				}
+				assert.Equal(t, e.status, statusFromError(err, 0))
+
+				unchanged, _ := json.Marshal(widget)
+				assert.JSONEq(t, string(original), string(unchanged))
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, e.expected, widget)
+		})
+	}
+}
+
+type patchAuditInfo struct {
+	UpdatedBy string `json:"updated_by"`
+	revision  int
+}
+
+type patchAccount struct {
+	patchAuditInfo
+	Name     string          `json:"name"`
+	Password string          `json:"-"`
+	Owner    *patchAuditInfo `json:"owner"`
+	Created  time.Time       `json:"created"`
+	version  int
+}
+
+func TestTools_ReadPatch_HiddenFields(t *testing.T) {
+	var testTools Tools
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	owner := &patchAuditInfo{UpdatedBy: "alice", revision: 7}
+	account := patchAccount{
+		patchAuditInfo: patchAuditInfo{UpdatedBy: "alice", revision: 3},
+		Name:           "old",
+		Password:       "secret",
+		Owner:          owner,
+		Created:        created,
+		version:        2,
+	}
+
+	req, _ := http.NewRequest("PATCH", "/", strings.NewReader(`{"name":"new","updated_by":"bob","owner":{"updated_by":"carol"},"created":"2025-01-01T00:00:00Z"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	assert.NoError(t, testTools.ReadPatch(httptest.NewRecorder(), req, &account))
+
+	assert.Equal(t, "new", account.Name)
+	assert.Equal(t, "secret", account.Password)
+	assert.Equal(t, 2, account.version)
+	assert.Equal(t, patchAuditInfo{UpdatedBy: "bob", revision: 3}, account.patchAuditInfo)
+	assert.Equal(t, &patchAuditInfo{UpdatedBy: "carol", revision: 7}, account.Owner)
+	assert.Equal(t, created.AddDate(1, 0, 0), account.Created)
+
+	// the struct the target pointed to is left alone
+	assert.Equal(t, "alice", owner.UpdatedBy)
+
+	// removing a field still zeroes it
+	req, _ = http.NewRequest("PATCH", "/", strings.NewReader(`[{"op":"remove","path":"/name"}]`))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	assert.NoError(t, testTools.ReadPatch(httptest.NewRecorder(), req, &account))
+	assert.Empty(t, account.Name)
+	assert.Equal(t, "secret", account.Password)
+}
+
+type patchSettings struct {
+	Limit   int               `json:"limit,omitempty"`
+	Label   string            `json:"label,omitempty"`
+	Owner   *patchAuditInfo   `json:"owner,omitempty"`
+	Nested  patchAuditInfo    `json:"nested"`
+	Extra   map[string]string `json:"extra,omitempty"`
+	Retries int               `json:"retries,omitempty,string"`
+}
+
+func TestTools_ReadPatch_OmittedFields(t *testing.T) {
+	var testTools Tools
+	// an empty map is left out by omitempty, but is still {} in the document; a nil map is null
+	settings := patchSettings{Extra: map[string]string{}}
+
+	req, _ := http.NewRequest("PATCH", "/", strings.NewReader(`[
+		{"op":"test","path":"/limit","value":0},
+		{"op":"replace","path":"/limit","value":5},
+		{"op":"replace","path":"/label","value":"a"},
+		{"op":"replace","path":"/owner","value":{"updated_by":"bob"}},
+		{"op":"add","path":"/extra/k","value":"v"},
+		{"op":"replace","path":"/retries","value":"3"}
+	]`))
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	err := testTools.ReadPatch(httptest.NewRecorder(), req, &settings)
+	assert.NoError(t, err)
+	assert.Equal(t, patchSettings{Limit: 5, Label: "a", Owner: &patchAuditInfo{UpdatedBy: "bob"}, Extra: map[string]string{"k": "v"}, Retries: 3}, settings)
+}
@@ -0,0 +1,160 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// problemContentType is the media type defined by RFC 9457 for problem details.
+const problemContentType = "application/problem+json"
+
+// problemMembers are the names of the standard problem details members.
+var problemMembers = []string{"type", "title", "status", "detail", "instance"}
+
+// Problem is an RFC 9457 problem details object. Extensions holds any additional members,
+// which are written at the top level of the JSON object alongside the standard ones.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+// ProblemExtender is implemented by errors that can describe themselves with additional
+// problem details members. ErrorJSON adds these members to the response when UseProblemDetails is set.
+type ProblemExtender interface {
+	ProblemExtensions() map[string]any
+}
+
+// NewProblem returns a Problem for the given status code, using the standard status text as its title.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Error implements the error interface, so that a *Problem can be returned and passed to ErrorJSON as is.
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+
+	return p.Title
+}
+
+// MarshalJSON writes the standard members, omitting empty ones, followed by the extension members.
+// Extensions named like a standard member are left out, even when that member is empty.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	out := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		if !slices.Contains(problemMembers, k) {
+			out[k] = v
+		}
+	}
+
+	out["type"] = "about:blank"
+	if p.Type != "" {
+		out["type"] = p.Type
+	}
+	if p.Title != "" {
+		out["title"] = p.Title
+	}
+	if p.Status != 0 {
+		out["status"] = p.Status
+	}
+	if p.Detail != "" {
+		out["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		out["instance"] = p.Instance
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads the standard members into their fields and everything else into Extensions.
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var std struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail"`
+		Instance string `json:"instance"`
+	}
+	if err := json.Unmarshal(data, &std); err != nil {
+		return err
+	}
+
+	var all map[string]any
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for _, k := range problemMembers {
+		delete(all, k)
+	}
+
+	p.Type, p.Title, p.Status, p.Detail, p.Instance = std.Type, std.Title, std.Status, std.Detail, std.Instance
+	p.Extensions = nil
+	if len(all) > 0 {
+		p.Extensions = all
+	}
+
+	return nil
+}
+
+// ProblemJSON writes p to the client as application/problem+json, using p.Status as the response
+// status code (500 if it is not set).
+func (t *Tools) ProblemJSON(w http.ResponseWriter, p *Problem, headers ...http.Header) error {
+	status := p.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	return t.writeJSON(w, status, p, problemContentType, headers...)
+}
+
+// problemFromError builds a Problem describing err, with the given status, which is the one the
+// response is sent with. If err is, or wraps, a *Problem, a copy of it is used, with its title
+// following the status if it was the standard text of its own status; otherwise a new one is
+// created with err's message as the detail. Members from any ProblemExtender in err's chain are
+// added to the extensions.
+func problemFromError(err error, status int) *Problem {
+	var p *Problem
+	var existing *Problem
+	if errors.As(err, &existing) {
+		cp := *existing
+		p = &cp
+		if p.Status != status && (p.Title == "" || p.Title == http.StatusText(p.Status)) {
+			p.Title = http.StatusText(status)
+		}
+		p.Status = status
+	} else {
+		p = NewProblem(status, err.Error())
+	}
+
+	var extender ProblemExtender
+	if errors.As(err, &extender) {
+		ext := make(map[string]any, len(p.Extensions))
+		for k, v := range p.Extensions {
+			ext[k] = v
+		}
+		for k, v := range extender.ProblemExtensions() {
+			ext[k] = v
+		}
+		p.Extensions = ext
+	}
+
+	return p
+}
+
+// ProblemExtensions lists every failing field under the "errors" member.
+func (v ValidationErrors) ProblemExtensions() map[string]any {
+	return map[string]any{"errors": []FieldError(v)}
+}
@@ -0,0 +1,116 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTools_ProblemJSON(t *testing.T) {
+	var testTools Tools
+
+	rr := httptest.NewRecorder()
+	p := NewProblem(http.StatusNotFound, "widget 42 does not exist")
+	p.Instance = "/widgets/42"
+	p.Extensions = map[string]any{"widget_id": 42, "title": "ignored"}
+
+	err := testTools.ProblemJSON(rr, p)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+
+	var body map[string]any
+	err = json.NewDecoder(rr.Body).Decode(&body)
+	assert.NoError(t, err)
+	assert.Equal(t, "about:blank", body["type"])
+	assert.Equal(t, "Not Found", body["title"])
+	assert.Equal(t, float64(http.StatusNotFound), body["status"])
+	assert.Equal(t, "widget 42 does not exist", body["detail"])
+	assert.Equal(t, "/widgets/42", body["instance"])
+	assert.Equal(t, float64(42), body["widget_id"])
+
+	// extensions do not stand in for empty standard members either
+	p = &Problem{Status: http.StatusNotFound, Extensions: map[string]any{"title": "ignored", "detail": "ignored"}}
+	data, err := json.Marshal(p)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"type":"about:blank","status":404}`, string(data))
+}
+
+var problemErrorTests = []struct {
+	name           string
+	err            error
+	status         int
+	expectedStatus int
+	expectedTitle  string
+	expectedDetail string
+	expectErrors   bool
+}{
+	{
+		name:           "plain error",
+		err:            errors.New("some error"),
+		status:         http.StatusServiceUnavailable,
+		expectedStatus: http.StatusServiceUnavailable,
+		expectedDetail: "some error",
+	},
+	{
+		name:           "wrapped problem",
+		err:            fmt.Errorf("wrapped: %w", NewProblem(http.StatusConflict, "already exists")),
+		expectedStatus: http.StatusConflict,
+		expectedTitle:  "Conflict",
+		expectedDetail: "already exists",
+	},
+	{
+		name:           "explicit status over wrapped problem",
+		err:            fmt.Errorf("wrapped: %w", NewProblem(http.StatusConflict, "already exists")),
+		status:         http.StatusServiceUnavailable,
+		expectedStatus: http.StatusServiceUnavailable,
+		expectedTitle:  "Service Unavailable",
+		expectedDetail: "already exists",
+	},
+	{
+		name:           "validation errors",
+		err:            ValidationErrors{{Field: "name", Rule: "required", Message: "name is required"}},
+		status:         http.StatusUnprocessableEntity,
+		expectedStatus: http.StatusUnprocessableEntity,
+		expectedDetail: "validation failed: name is required",
+		expectErrors:   true,
+	},
+}
+
+func TestTools_ErrorJSONProblemDetails(t *testing.T) {
+	testTools := Tools{UseProblemDetails: true}
+
+	for _, e := range problemErrorTests {
+		t.Run(e.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			var status []int
+			if e.status != 0 {
+				status = append(status, e.status)
+			}
+			err := testTools.ErrorJSON(rr, e.err, status...)
+			assert.NoError(t, err)
+			assert.Equal(t, e.expectedStatus, rr.Code)
+			assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+
+			var p Problem
+			err = json.NewDecoder(rr.Body).Decode(&p)
+			assert.NoError(t, err)
+			assert.Equal(t, e.expectedStatus, p.Status)
+			assert.Equal(t, e.expectedDetail, p.Detail)
+			if e.expectedTitle != "" {
+				assert.Equal(t, e.expectedTitle, p.Title)
+			}
+
+			if e.expectErrors {
+				assert.Len(t, p.Extensions["errors"], 1)
+			} else {
+				assert.Nil(t, p.Extensions)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package toolkit
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayouts are the formats accepted for time.Time fields, in the order they are tried.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// ReadQuery decodes the query string of r into data, which must be a pointer to a struct.
+// Fields are matched by their query tag, falling back to their json tag and then their name,
+// and may have a default tag giving the value to use when the parameter is missing. Strings,
+// booleans, numbers, time.Time (RFC 3339 or 2006-01-02), time.Duration, encoding.TextUnmarshaler
+// implementations, pointers and slices of these (from repeated parameters) are supported; the
+// default of a slice is a comma separated list. Embedded structs are flattened and other nested
+// structs use dotted names, e.g. "owner.name". Unknown parameters are rejected unless
+// AllowUnknownFields is set, except for those the toolkit reads itself, limit, offset, cursor
+// and fields, which are ignored when data has no field for them. ValidateJSON applies.
+// Problems are reported as a *QueryParamError.
+func (t *Tools) ReadQuery(r *http.Request, data any) error {
+	return t.decodeValues(r.URL.Query(), data, "query", "query", reservedQueryParams)
+}
+
+// reservedQueryParams are the parameters read by ReadPageRequest and SparseFields.
+var reservedQueryParams = map[string]bool{"limit": true, "offset": true, "cursor": true, "fields": true}
+
+// ReadForm is like ReadQuery, but decodes the fields of an application/x-www-form-urlencoded or
+// multipart/form-data request body, matched by their form tag. The body may be at most MaxJSONSize bytes.
+func (t *Tools) ReadForm(w http.ResponseWriter, r *http.Request, data any) error {
+	maxBytes := t.maxJSONBytes()
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		err = r.ParseForm()
+	case "multipart/form-data":
+		err = r.ParseMultipartForm(maxBytes)
+	default:
+		return &UnsupportedMediaTypeError{ContentType: r.Header.Get("Content-Type")}
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return &BodyTooLargeError{Limit: maxBytes}
+		}
+		return &BodyDecodeError{ContentType: mediaType, Err: err}
+	}
+
+	values := r.PostForm
+	if r.MultipartForm != nil {
+		values = url.Values(r.MultipartForm.Value)
+	}
+
+	return t.decodeValues(values, data, "form", "form", nil)
+}
+
+// decodeValues decodes values into the struct pointed to by data, using tagKey to name fields.
+// source names where the values came from, for error messages. Parameters in ignored are never
+// rejected as unknown.
+func (t *Tools) decodeValues(values url.Values, data any, tagKey, source string, ignored map[string]bool) error {
+	rv := reflect.ValueOf(data)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("error decoding %s: destination must be a non-nil pointer to a struct, not %T", source, data)
+	}
+
+	used := make(map[string]bool)
+	if err := decodeStructValues(values, rv.Elem(), "", tagKey, source, used); err != nil {
+		return err
+	}
+
+	if !t.AllowUnknownFields {
+		for name := range values {
+			if !used[name] && !ignored[name] {
+				return &QueryParamError{Source: source, Param: name, Value: values.Get(name), Message: "is not allowed"}
+			}
+		}
+	}
+
+	if t.ValidateJSON {
+		return t.Validate(data)
+	}
+
+	return nil
+}
+
+// decodeStructValues sets the fields of the struct v from values, recording the parameter names it uses.
+func decodeStructValues(values url.Values, v reflect.Value, prefix, tagKey, source string, used map[string]bool) error {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		fv := v.Field(i)
+
+		name, skip := valueFieldName(sf, tagKey)
+		if skip {
+			continue
+		}
+
+		ft := sf.Type
+		isStruct := ft.Kind() == reflect.Struct || (ft.Kind() == reflect.Pointer && ft.Elem().Kind() == reflect.Struct)
+		isValue := ft == timeType || reflect.PointerTo(ft).Implements(textUnmarshalerType) ||
+			(ft.Kind() == reflect.Pointer && (ft.Elem() == timeType || ft.Implements(textUnmarshalerType)))
+
+		if sf.Anonymous && isStruct && !isValue && sf.Tag.Get(tagKey) == "" {
+			if ft.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					if !sf.IsExported() {
+						continue
+					}
+					fv.Set(reflect.New(ft.Elem()))
+				}
+				fv = fv.Elem()
+			}
+			if err := decodeStructValues(values, fv, prefix, tagKey, source, used); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !sf.IsExported() {
+			continue
+		}
+
+		param := prefix + name
+
+		if isStruct && !isValue {
+			if ft.Kind() == reflect.Pointer {
+				if !hasPrefixedValue(values, param+".") {
+					continue
+				}
+				if fv.IsNil() {
+					fv.Set(reflect.New(ft.Elem()))
+				}
+				fv = fv.Elem()
+			}
+			if err := decodeStructValues(values, fv, param+".", tagKey, source, used); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := values[param]
+		if !ok || len(raw) == 0 {
+			def, hasDefault := sf.Tag.Lookup("default")
+			if !hasDefault {
+				continue
+			}
+			raw = []string{def}
+			if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+				raw = strings.Split(def, ",")
+			}
+		}
+		used[param] = true
+
+		if err := setFieldValue(fv, raw); err != nil {
+			return &QueryParamError{Source: source, Param: param, Value: strings.Join(raw, ","), Message: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// hasPrefixedValue reports whether any parameter name in values starts with prefix.
+func hasPrefixedValue(values url.Values, prefix string) bool {
+	for name := range values {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// valueFieldName returns the parameter name for sf, from tagKey, then json, then the field name.
+func valueFieldName(sf reflect.StructField, tagKey string) (string, bool) {
+	tag, ok := sf.Tag.Lookup(tagKey)
+	if !ok {
+		return jsonFieldName(sf)
+	}
+	if tag == "-" {
+		return "", true
+	}
+
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = sf.Name
+	}
+
+	return name, false
+}
+
+// setFieldValue stores raw, the values of one parameter, in fv.
+func setFieldValue(fv reflect.Value, raw []string) error {
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(fv.Type(), len(raw), len(raw))
+		for i, s := range raw {
+			if err := setScalarValue(slice.Index(i), s); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+
+	return setScalarValue(fv, raw[len(raw)-1])
+}
+
+// setScalarValue parses s into fv, allocating pointers as needed.
+func setScalarValue(fv reflect.Value, s string) error {
+	if fv.Kind() == reflect.Pointer {
+		ptr := reflect.New(fv.Type().Elem())
+		if err := setScalarValue(ptr.Elem(), s); err != nil {
+			return err
+		}
+		fv.Set(ptr)
+		return nil
+	}
+
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) && fv.Type() != timeType {
+		if err := fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("has an invalid value: %w", err)
+		}
+		return nil
+	}
+
+	switch fv.Type() {
+	case timeType:
+		for _, layout := range timeLayouts {
+			if tm, err := time.Parse(layout, s); err == nil {
+				fv.Set(reflect.ValueOf(tm))
+				return nil
+			}
+		}
+		return errors.New("must be a time in RFC 3339 or YYYY-MM-DD format")
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.New("must be a duration, such as 1h30m")
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("must be a boolean")
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+		if err != nil {
+			return errors.New("must be a non-negative integer")
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, fv.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("cannot be decoded into %s", fv.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package toolkit
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type queryPaging struct {
+	Limit int `query:"limit" form:"limit" default:"20"`
+}
+
+type queryFilter struct {
+	queryPaging
+	Search  string        `query:"q" form:"q"`
+	Tags    []string      `query:"tag" form:"tag"`
+	States  []string      `query:"state" default:"open,pending"`
+	Active  *bool         `query:"active" form:"active"`
+	Since   time.Time     `query:"since" form:"since"`
+	Timeout time.Duration `query:"timeout"`
+	Owner   struct {
+		Name string `query:"name" form:"name"`
+	} `query:"owner" form:"owner"`
+	Ignored string `query:"-" form:"-"`
+}
+
+var readQueryTests = []struct {
+	name          string
+	query         string
+	allowUnknown  bool
+	check         func(t *testing.T, f queryFilter)
+	errorParam    string
+	errorExpected bool
+}{
+	{name: "defaults", query: "", check: func(t *testing.T, f queryFilter) {
+		assert.Equal(t, 20, f.Limit)
+		assert.Nil(t, f.Active)
+		assert.True(t, f.Since.IsZero())
+		assert.Equal(t, []string{"open", "pending"}, f.States)
+	}},
+	{name: "slice default is replaced", query: "state=closed", check: func(t *testing.T, f queryFilter) {
+		assert.Equal(t, []string{"closed"}, f.States)
+	}},
+	{name: "toolkit params are ignored", query: "offset=10&cursor=abc&fields=id,name", check: func(t *testing.T, f queryFilter) {
+		assert.Equal(t, 20, f.Limit)
+	}},
+	{name: "all fields", query: "q=go&tag=a&tag=b&active=true&since=2024-03-01&timeout=1m30s&limit=5&owner.name=ann", check: func(t *testing.T, f queryFilter) {
+		assert.Equal(t, "go", f.Search)
+		assert.Equal(t, []string{"a", "b"}, f.Tags)
+		assert.True(t, *f.Active)
+		assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), f.Since)
+		assert.Equal(t, 90*time.Second, f.Timeout)
+		assert.Equal(t, 5, f.Limit)
+		assert.Equal(t, "ann", f.Owner.Name)
+	}},
+	{name: "rfc 3339 time", query: "since=2024-03-01T10:00:00Z", check: func(t *testing.T, f queryFilter) {
+		assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), f.Since)
+	}},
+	{name: "bad int", query: "limit=ten", errorParam: "limit", errorExpected: true},
+	{name: "bad bool", query: "active=maybe", errorParam: "active", errorExpected: true},
+	{name: "bad time", query: "since=yesterday", errorParam: "since", errorExpected: true},
+	{name: "unknown param", query: "page=2", errorParam: "page", errorExpected: true},
+	{name: "ignored field is unknown", query: "Ignored=x", errorParam: "Ignored", errorExpected: true},
+	{name: "unknown param allowed", query: "page=2", allowUnknown: true, check: func(t *testing.T, f queryFilter) {
+		assert.Equal(t, 20, f.Limit)
+	}},
+}
+
+func TestTools_ReadQuery(t *testing.T) {
+	for _, e := range readQueryTests {
+		t.Run(e.name, func(t *testing.T) {
+			testTools := Tools{AllowUnknownFields: e.allowUnknown}
+			req, _ := http.NewRequest("GET", "/items?"+e.query, nil)
+
+			var f queryFilter
+			err := testTools.ReadQuery(req, &f)
+
+			if e.errorExpected {
+				assert.ErrorIs(t, err, ErrBadQuery)
+				var qpe *QueryParamError
+				if assert.ErrorAs(t, err, &qpe) {
+					assert.Equal(t, e.errorParam, qpe.Param)
+					assert.Equal(t, "query", qpe.Source)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			e.check(t, f)
+		})
+	}
+}
+
+func TestTools_ReadQuery_Validate(t *testing.T) {
+	testTools := Tools{ValidateJSON: true}
+
+	var f struct {
+		Q string `query:"q" validate:"required"`
+	}
+
+	req, _ := http.NewRequest("GET", "/items", nil)
+	err := testTools.ReadQuery(req, &f)
+
+	var verrs ValidationErrors
+	assert.ErrorAs(t, err, &verrs)
+}
+
+func TestTools_ReadQuery_NotStruct(t *testing.T) {
+	var testTools Tools
+	req, _ := http.NewRequest("GET", "/items", nil)
+
+	var s string
+	assert.Error(t, testTools.ReadQuery(req, &s))
+}
+
+func TestTools_ReadForm(t *testing.T) {
+	var testTools Tools
+
+	req := httptest.NewRequest("POST", "/items", strings.NewReader("q=go&tag=a&tag=b&active=false"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var f queryFilter
+	assert.NoError(t, testTools.ReadForm(httptest.NewRecorder(), req, &f))
+	assert.Equal(t, "go", f.Search)
+	assert.Equal(t, []string{"a", "b"}, f.Tags)
+	assert.False(t, *f.Active)
+	assert.Equal(t, 20, f.Limit)
+
+	// query string parameters are not form fields
+	req = httptest.NewRequest("POST", "/items?q=query", strings.NewReader("tag=a"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	f = queryFilter{}
+	assert.NoError(t, testTools.ReadForm(httptest.NewRecorder(), req, &f))
+	assert.Empty(t, f.Search)
+
+	req = httptest.NewRequest("POST", "/items", strings.NewReader("limit=x"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	err := testTools.ReadForm(httptest.NewRecorder(), req, &f)
+	var qpe *QueryParamError
+	if assert.ErrorAs(t, err, &qpe) {
+		assert.Equal(t, "form", qpe.Source)
+		assert.Contains(t, err.Error(), `form parameter "limit"`)
+	}
+}
+
+func TestTools_ReadForm_Multipart(t *testing.T) {
+	var testTools Tools
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("q", "go")
+	_ = mw.WriteField("owner.name", "ann")
+	_ = mw.Close()
+
+	req := httptest.NewRequest("POST", "/items", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var f queryFilter
+	assert.NoError(t, testTools.ReadForm(httptest.NewRecorder(), req, &f))
+	assert.Equal(t, "go", f.Search)
+	assert.Equal(t, "ann", f.Owner.Name)
+}
+
+func TestTools_ReadForm_Errors(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 10}
+
+	req := httptest.NewRequest("POST", "/items", strings.NewReader("q=go"))
+	req.Header.Set("Content-Type", "application/json")
+	var f queryFilter
+	assert.ErrorIs(t, testTools.ReadForm(httptest.NewRecorder(), req, &f), ErrUnsupportedMediaType)
+
+	req = httptest.NewRequest("POST", "/items", strings.NewReader("q="+strings.Repeat("a", 100)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.ErrorIs(t, testTools.ReadForm(httptest.NewRecorder(), req, &f), ErrBodyTooLarge)
+}
@@ -0,0 +1,68 @@
+package toolkit
+
+import (
+	"net/http"
+	"time"
+)
+
+// requestWriter is an http.ResponseWriter that remembers the request it is answering, so that
+// WriteJSON and the other writers can take request headers such as Accept-Encoding into account.
+type requestWriter struct {
+	http.ResponseWriter
+	req   *http.Request
+	id    string
+	start time.Time
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by http.ResponseController.
+func (rw *requestWriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
+
+// WithRequest returns a ResponseWriter that carries r along with w. Writing a response through it
+// lets WriteJSON, ErrorJSON and the other writers apply the request-dependent features configured
+// on Tools, such as compression. Write does this automatically; Middleware does it for every handler.
+// The request's ID, see RequestID, is set in the RequestIDHeader of the response. If w already
+// comes from WithRequest, the response keeps its ID and start time and only the request changes.
+func (t *Tools) WithRequest(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if rw := requestWriterOf(w); rw != nil {
+		if rw.req == r {
+			return w
+		}
+
+		return &requestWriter{ResponseWriter: w, req: r, id: rw.id, start: rw.start}
+	}
+
+	id := t.requestID(r)
+	w.Header().Set(t.requestIDHeader(), id)
+
+	return &requestWriter{ResponseWriter: w, req: r, id: id, start: time.Now()}
+}
+
+// Middleware wraps next so that every handler receives a ResponseWriter from WithRequest.
+func (t *Tools) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(t.WithRequest(w, r), r)
+	})
+}
+
+// requestOf returns the request carried by w, or nil if w did not come from WithRequest.
+func requestOf(w http.ResponseWriter) *http.Request {
+	if rw := requestWriterOf(w); rw != nil {
+		return rw.req
+	}
+
+	return nil
+}
+
+// requestWriterOf finds the requestWriter w is or wraps, or returns nil if there is none.
+func requestWriterOf(w http.ResponseWriter) *requestWriter {
+	for {
+		switch v := w.(type) {
+		case *requestWriter:
+			return v
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Defaults used by RetryPolicy when its fields are not set.
+const (
+	defaultRetryAttempts  = 3
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 10 * time.Second
+)
+
+// Clock tells the time and waits. Tools uses it for retry delays and the other time-dependent
+// features of its HTTP client, so that tests can substitute a fake clock.
+type Clock interface {
+	Now() time.Time
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// clock returns Clock, or the real clock.
+func (t *Tools) clock() Clock {
+	if t.Clock != nil {
+		return t.Clock
+	}
+
+	return realClock{}
+}
+
+// RetryPolicy controls how outbound requests made by DoJSON and PushJSONToRemote are retried.
+// Only requests with an idempotent method (GET, HEAD, OPTIONS, TRACE, PUT and DELETE) or an
+// Idempotency-Key header are retried, since repeating any other request could repeat its effect.
+// Delays grow exponentially from BaseDelay up to MaxDelay, with full jitter: each delay is a
+// random duration between zero and the exponential value. A Retry-After header on the response is
+// honoured instead, unless it asks for a longer wait than MaxDelay, in which case the response is
+// returned as it is.
+type RetryPolicy struct {
+	MaxAttempts int           // attempts in total, including the first; 0 means 3
+	BaseDelay   time.Duration // delay before the first retry, before jitter; 0 means 100ms
+	MaxDelay    time.Duration // largest delay between attempts; 0 means 10s
+
+	// RetryIf decides whether an attempt failed in a way worth retrying. err is the error from
+	// sending the request, or nil if resp holds the response. nil means DefaultRetryIf.
+	RetryIf func(resp *http.Response, err error) bool
+
+	OnRetry  func(RetryEvent) // called before waiting to retry
+	OnGiveUp func(RetryEvent) // called when the last attempt fails in a retryable way
+
+	Random func() float64 // source of jitter in [0, 1); nil means math/rand
+}
+
+// RetryEvent describes a failed attempt, for the RetryPolicy hooks. Delay is the wait before
+// the next attempt, and is zero when giving up.
+type RetryEvent struct {
+	Method     string
+	URL        string
+	Attempt    int
+	Delay      time.Duration
+	StatusCode int
+	Err        error
+}
+
+// DefaultRetryIf retries connection errors and the 408, 429, 502, 503 and 504 status codes.
+func DefaultRetryIf(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// retryable reports whether a request may be sent more than once.
+func retryable(method string, header http.Header) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	return header.Get(idempotencyKeyHeader) != ""
+}
+
+// do calls send until it succeeds, fails in a way that should not be retried, or runs out of
+// attempts, waiting on clock between attempts. It returns the last response or error.
+func (p *RetryPolicy) do(ctx context.Context, clock Clock, method, uri string, header http.Header, send func() (*http.Response, error)) (*http.Response, error) {
+	attempts := p.maxAttempts()
+	if !retryable(method, header) {
+		attempts = 1
+	}
+	retryIf := p.RetryIf
+	if retryIf == nil {
+		retryIf = DefaultRetryIf
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := send()
+		if ctx.Err() != nil || errors.Is(err, ErrCircuitOpen) || !retryIf(resp, err) {
+			return resp, err
+		}
+
+		event := RetryEvent{Method: method, URL: uri, Attempt: attempt, Err: err}
+		if resp != nil {
+			event.StatusCode = resp.StatusCode
+		}
+
+		delay, ok := p.delay(clock, attempt, resp)
+		if attempt >= attempts || !ok {
+			if p.OnGiveUp != nil {
+				p.OnGiveUp(event)
+			}
+			return resp, err
+		}
+
+		event.Delay = delay
+		if p.OnRetry != nil {
+			p.OnRetry(event)
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		if err := clock.Sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// delay returns how long to wait after the given attempt, and false if the response's
+// Retry-After asks for a longer wait than MaxDelay.
+func (p *RetryPolicy) delay(clock Clock, attempt int, resp *http.Response) (time.Duration, bool) {
+	maxDelay := p.maxDelay()
+
+	if resp != nil {
+		if d, ok := retryAfter(resp.Header.Get("Retry-After"), clock.Now()); ok {
+			return d, d <= maxDelay
+		}
+	}
+
+	base := p.BaseDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	backoff := maxDelay
+	if shift := attempt - 1; shift < 32 && base<<shift > 0 && base<<shift < maxDelay {
+		backoff = base << shift
+	}
+
+	random := p.Random
+	if random == nil {
+		random = rand.Float64
+	}
+
+	return time.Duration(random() * float64(backoff)), true
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+
+	return defaultRetryAttempts
+}
+
+func (p *RetryPolicy) maxDelay() time.Duration {
+	if p.MaxDelay > 0 {
+		return p.MaxDelay
+	}
+
+	return defaultRetryMaxDelay
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as an HTTP date. Waits too long
+// to represent are returned as the longest Duration.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil || errors.Is(err, strconv.ErrRange) {
+		if secs > int64(math.MaxInt64/time.Second) {
+			// too long to represent, and so longer than any MaxDelay
+			return math.MaxInt64, true
+		}
+		return time.Duration(max(secs, 0)) * time.Second, true
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		return max(when.Sub(now), 0), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,214 @@
+package toolkit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a Clock whose Sleep returns at once, advancing the time and recording the delay.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	return ctx.Err()
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// statusSequence returns a client answering with each status in turn, and the last one after
+// that, and a function reporting how many requests it received. A zero status fails the request.
+func statusSequence(statuses ...int) (*http.Client, func() int) {
+	var mu sync.Mutex
+	calls := 0
+
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		mu.Lock()
+		defer mu.Unlock()
+		status := statuses[min(calls, len(statuses)-1)]
+		calls++
+		if status == 0 {
+			return nil
+		}
+		header := make(http.Header)
+		if status == http.StatusTooManyRequests {
+			header.Set("Retry-After", "2")
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(`{}`)), Header: header}
+	})
+
+	return client, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+var retryTests = []struct {
+	name           string
+	method         string
+	idempotencyKey string
+	statuses       []int
+	expectedStatus int
+	expectedCalls  int
+	expectedSleeps []time.Duration
+}{
+	{name: "success", method: http.MethodGet, statuses: []int{200}, expectedStatus: 200, expectedCalls: 1},
+	{name: "retry then success", method: http.MethodGet, statuses: []int{503, 502, 200}, expectedStatus: 200, expectedCalls: 3,
+		expectedSleeps: []time.Duration{50 * time.Millisecond, 100 * time.Millisecond}},
+	{name: "gives up", method: http.MethodPut, statuses: []int{503}, expectedStatus: 503, expectedCalls: 4,
+		expectedSleeps: []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 150 * time.Millisecond}},
+	{name: "connection error", method: http.MethodDelete, statuses: []int{0, 200}, expectedStatus: 200, expectedCalls: 2,
+		expectedSleeps: []time.Duration{50 * time.Millisecond}},
+	{name: "not retryable status", method: http.MethodGet, statuses: []int{500, 200}, expectedStatus: 500, expectedCalls: 1},
+	{name: "post is not retried", method: http.MethodPost, statuses: []int{503, 200}, expectedStatus: 503, expectedCalls: 1},
+	{name: "post with idempotency key", method: http.MethodPost, idempotencyKey: "k", statuses: []int{503, 200}, expectedStatus: 200, expectedCalls: 2,
+		expectedSleeps: []time.Duration{50 * time.Millisecond}},
+	{name: "retry after", method: http.MethodGet, statuses: []int{429, 200}, expectedStatus: 200, expectedCalls: 2,
+		expectedSleeps: []time.Duration{2 * time.Second}},
+}
+
+func TestTools_Retry(t *testing.T) {
+	for _, e := range retryTests {
+		t.Run(e.name, func(t *testing.T) {
+			client, calls := statusSequence(e.statuses...)
+			clock := newFakeClock()
+			var retries, giveUps []RetryEvent
+
+			testTools := Tools{
+				HTTPClient: client,
+				Clock:      clock,
+				Retry: &RetryPolicy{
+					MaxAttempts: 4,
+					BaseDelay:   100 * time.Millisecond,
+					MaxDelay:    300 * time.Millisecond,
+					Random:      func() float64 { return 0.5 },
+					OnRetry:     func(ev RetryEvent) { retries = append(retries, ev) },
+					OnGiveUp:    func(ev RetryEvent) { giveUps = append(giveUps, ev) },
+				},
+			}
+			// with half the jitter range, the delays are 50ms, 100ms and then capped at 150ms
+			if e.name == "retry after" {
+				testTools.Retry.MaxDelay = 5 * time.Second
+			}
+
+			var opts []RequestOption
+			if e.idempotencyKey != "" {
+				opts = append(opts, WithHeader("Idempotency-Key", e.idempotencyKey))
+			}
+
+			resp, err := testTools.DoJSON(context.Background(), e.method, "http://example.com", nil, nil, opts...)
+			if resp != nil {
+				assert.Equal(t, e.expectedStatus, resp.StatusCode)
+			}
+			if e.expectedStatus == 200 {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, e.expectedCalls, calls())
+			assert.Equal(t, e.expectedSleeps, clock.sleeps)
+			assert.Len(t, retries, len(e.expectedSleeps))
+			for i, ev := range retries {
+				assert.Equal(t, i+1, ev.Attempt)
+				assert.Equal(t, e.expectedSleeps[i], ev.Delay)
+			}
+			if e.name == "gives up" {
+				assert.Len(t, giveUps, 1)
+				assert.Equal(t, 4, giveUps[0].Attempt)
+			}
+		})
+	}
+}
+
+func TestTools_Retry_RetryAfterTooLong(t *testing.T) {
+	client, calls := statusSequence(429, 200)
+	testTools := Tools{HTTPClient: client, Clock: newFakeClock(), Retry: &RetryPolicy{MaxDelay: time.Second}}
+
+	resp, _ := testTools.DoJSON(context.Background(), http.MethodGet, "http://example.com", nil, nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, 1, calls())
+}
+
+func TestTools_Retry_Predicate(t *testing.T) {
+	client, calls := statusSequence(500, 200)
+	testTools := Tools{HTTPClient: client, Clock: newFakeClock(), Retry: &RetryPolicy{
+		RetryIf: func(resp *http.Response, err error) bool { return err != nil || resp.StatusCode >= 500 },
+	}}
+
+	_, err := testTools.DoJSON(context.Background(), http.MethodGet, "http://example.com", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls())
+}
+
+func TestTools_Retry_BodyResent(t *testing.T) {
+	var bodies []string
+	testTools := Tools{Clock: newFakeClock(), Retry: &RetryPolicy{}, HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+		b, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(b))
+		status := http.StatusServiceUnavailable
+		if len(bodies) == 2 {
+			status = http.StatusOK
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(``)), Header: make(http.Header)}
+	})}
+
+	_, err := testTools.PutJSON(context.Background(), "http://example.com", map[string]int{"a": 1}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"a":1}`, `{"a":1}`}, bodies)
+
+	// POST is not idempotent, so PushJSONToRemote sends it once
+	bodies = nil
+	_, statusCode, err := testTools.PushJSONToRemote("http://example.com", map[string]int{"a": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	assert.Len(t, bodies, 1)
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, Random: func() float64 { return 0.999 }}
+	clock := newFakeClock()
+
+	for attempt, limit := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		d, ok := p.delay(clock, attempt+1, nil)
+		assert.True(t, ok)
+		assert.LessOrEqual(t, d, limit)
+		assert.Greater(t, d, limit*99/100)
+	}
+
+	d, ok := retryAfter(clock.Now().Add(3*time.Second).Format(http.TimeFormat), clock.Now())
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+
+	for _, value := range []string{"9223372037", "99999999999999999999"} {
+		resp := &http.Response{Header: http.Header{"Retry-After": {value}}}
+		d, ok := p.delay(clock, 1, resp)
+		assert.False(t, ok, value)
+		assert.Positive(t, d, value)
+	}
+}
@@ -0,0 +1,372 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Standard JSON-RPC 2.0 error codes.
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	RPCServerError    = -32000
+)
+
+// RPCError is a JSON-RPC 2.0 error object. A method can return one to choose the code, message
+// and data sent to the client; the client returns one when the server answers with an error.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("json-rpc error %d: %s", e.Code, e.Message)
+}
+
+// rpcRequest is a JSON-RPC request or notification. ID is empty for a notification, and holds
+// null if the client sent a null id.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcMethod decodes params, calls a registered method and returns its encoded result.
+type rpcMethod func(ctx context.Context, params json.RawMessage) (any, error)
+
+// RPCServer is an http.Handler that serves JSON-RPC 2.0 over HTTP POST, including batches and
+// notifications. Register methods with RegisterRPC.
+type RPCServer struct {
+	// ErrorLog receives the errors of methods that are not sent to the client, whose messages may
+	// reveal internal details. nil means they are not logged.
+	ErrorLog *log.Logger
+
+	tools *Tools
+
+	mu      sync.RWMutex
+	methods map[string]rpcMethod
+}
+
+// NewRPCServer returns an RPCServer that reads requests with the limits and settings of t, such as
+// MaxJSONSize, and decodes method parameters like ReadJSON does.
+func (t *Tools) NewRPCServer() *RPCServer {
+	return &RPCServer{tools: t, methods: make(map[string]rpcMethod)}
+}
+
+// RegisterRPC registers fn as the method name of s. The request's params are decoded into P with
+// the same rules as ReadJSON, including AllowUnknownFields, StrictJSON and ValidateJSON, and a
+// failure is reported as -32602 Invalid params. Missing params leave P at its zero value. The
+// result of fn is encoded as the response's result. If fn returns an *RPCError it is sent as is;
+// ValidationErrors and SchemaErrors are sent as -32602 with the errors as data, and errors with an
+// HTTP status, such as ErrPreconditionFailed, as -32000 with their message. Any other error is
+// sent as -32000 "Server error", and its message is only logged to ErrorLog.
+func RegisterRPC[P, R any](s *RPCServer, name string, fn func(ctx context.Context, params P) (R, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.methods[name] = func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var params P
+		if len(raw) > 0 {
+			if err := s.tools.decodeJSON(raw, &params); err != nil {
+				return nil, rpcParamsError(err)
+			}
+		}
+
+		return fn(ctx, params)
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := s.tools
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		_ = t.ErrorJSON(w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := t.readJSONBody(w, r)
+	if err != nil {
+		code := RPCParseError
+		if errors.Is(err, ErrBodyTooLarge) {
+			code = RPCInvalidRequest
+		}
+		s.write(w, rpcErrorResponse(nil, code, err))
+		return
+	}
+
+	// check that the body is a single, well-formed JSON value within the structural limits
+	var raw json.RawMessage
+	if err := t.decodeJSON(body, &raw); err != nil {
+		code := RPCParseError
+		if errors.Is(err, ErrMaxDepth) || errors.Is(err, ErrMaxArrayLen) || errors.Is(err, ErrMaxStringLen) || errors.Is(err, ErrMaxKeys) {
+			code = RPCInvalidRequest
+		}
+		s.write(w, rpcErrorResponse(nil, code, err))
+		return
+	}
+
+	raw = bytes.TrimSpace(raw)
+	if raw[0] != '[' {
+		if resp := s.handle(r.Context(), raw); resp != nil {
+			s.write(w, resp)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(raw, &batch); err != nil || len(batch) == 0 {
+		s.write(w, rpcErrorResponse(nil, RPCInvalidRequest, errors.New("batch must be a non-empty array")))
+		return
+	}
+
+	responses := make([]*rpcResponse, 0, len(batch))
+	for _, item := range batch {
+		if resp := s.handle(r.Context(), item); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.write(w, responses)
+}
+
+// handle runs a single request and returns its response, or nil for a notification.
+func (s *RPCServer) handle(ctx context.Context, raw json.RawMessage) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return rpcErrorResponse(nil, RPCInvalidRequest, errors.New("request must be a JSON-RPC request object"))
+	}
+	if !validRPCID(req.ID) {
+		return rpcErrorResponse(nil, RPCInvalidRequest, errors.New("id must be a string, a number or null"))
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" || !validRPCParams(req.Params) {
+		return rpcErrorResponse(req.ID, RPCInvalidRequest, errors.New("request must be a JSON-RPC 2.0 request object"))
+	}
+
+	s.mu.RLock()
+	method, ok := s.methods[req.Method]
+	s.mu.RUnlock()
+
+	var result any
+	var err error
+	if ok {
+		result, err = method(ctx, req.Params)
+	} else {
+		err = &RPCError{Code: RPCMethodNotFound, Message: "Method not found"}
+	}
+
+	if err != nil && !rpcPublicError(err) {
+		s.logf("json-rpc: method %s: %v", req.Method, err)
+		err = errors.New("Server error")
+	}
+
+	if len(req.ID) == 0 {
+		return nil
+	}
+	if err != nil {
+		return rpcErrorResponse(req.ID, RPCServerError, err)
+	}
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		s.logf("json-rpc: method %s: encoding result: %v", req.Method, err)
+		return rpcErrorResponse(req.ID, RPCInternalError, errors.New("result cannot be encoded"))
+	}
+
+	return &rpcResponse{JSONRPC: "2.0", Result: out, ID: req.ID}
+}
+
+// write sends a response or batch of responses. It uses WriteJSON's encoding and compression but
+// not Envelope or field filtering, whose output would not be valid JSON-RPC.
+func (s *RPCServer) write(w http.ResponseWriter, payload any) {
+	_ = s.tools.writeJSON(w, http.StatusOK, payload, "application/json")
+}
+
+// logf logs a server-side error to ErrorLog.
+func (s *RPCServer) logf(format string, args ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	}
+}
+
+// rpcPublicError reports whether the message of a method's error may be sent to the client: an
+// *RPCError, validation errors, or an error with an HTTP status, which the toolkit's own errors
+// have. Other errors may hold internal details, such as a database error.
+func rpcPublicError(err error) bool {
+	var rpcErr *RPCError
+	var se HTTPStatusError
+	var verrs ValidationErrors
+	var serrs SchemaErrors
+
+	return errors.As(err, &rpcErr) || errors.As(err, &se) || errors.As(err, &verrs) || errors.As(err, &serrs)
+}
+
+// rpcErrorResponse builds an error response for id from err. An *RPCError in err's chain is used
+// as is; otherwise code is used with err's message.
+func rpcErrorResponse(id json.RawMessage, code int, err error) *rpcResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		var verrs ValidationErrors
+		var serrs SchemaErrors
+		switch {
+		case errors.As(err, &verrs):
+			rpcErr = &RPCError{Code: RPCInvalidParams, Message: "Invalid params", Data: verrs}
+		case errors.As(err, &serrs):
+			rpcErr = &RPCError{Code: RPCInvalidParams, Message: "Invalid params", Data: serrs}
+		default:
+			rpcErr = &RPCError{Code: code, Message: err.Error()}
+		}
+	}
+
+	return &rpcResponse{JSONRPC: "2.0", Error: rpcErr, ID: id}
+}
+
+// rpcParamsError reports params that cannot be decoded. Validation failures are left for
+// rpcErrorResponse, which sends the failing fields as data.
+func rpcParamsError(err error) error {
+	var verrs ValidationErrors
+	if errors.As(err, &verrs) {
+		return err
+	}
+
+	return &RPCError{Code: RPCInvalidParams, Message: "Invalid params", Data: err.Error()}
+}
+
+// validRPCID reports whether id is absent, a string, a number or null.
+func validRPCID(id json.RawMessage) bool {
+	if len(id) == 0 {
+		return true
+	}
+
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+
+	return false
+}
+
+// validRPCParams reports whether params is absent, an array or an object.
+func validRPCParams(params json.RawMessage) bool {
+	return len(params) == 0 || params[0] == '[' || params[0] == '{'
+}
+
+// RPCClient calls the methods of a JSON-RPC 2.0 server, using PushJSONToRemote's request handling.
+type RPCClient struct {
+	tools  *Tools
+	url    string
+	client []*http.Client
+	nextID atomic.Int64
+}
+
+// NewRPCClient returns a client for the JSON-RPC server at url. The final parameter, client, is
+// optional. If not provided, HTTPClient or a default client is used.
+func (t *Tools) NewRPCClient(url string, client ...*http.Client) *RPCClient {
+	return &RPCClient{tools: t, url: url, client: client}
+}
+
+// Call calls method with params and decodes its result into result, which may be nil to discard
+// it. If the server answers with an error, it is returned as an *RPCError.
+func (c *RPCClient) Call(method string, params any, result any) error {
+	id := json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10))
+
+	resp, err := c.send(method, params, id)
+	if err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if !bytes.Equal(resp.ID, id) {
+		return fmt.Errorf("json-rpc response has id %s, expected %s", resp.ID, id)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("error decoding json-rpc result: %w", err)
+	}
+
+	return nil
+}
+
+// Notify sends method and params as a notification, to which the server does not reply.
+func (c *RPCClient) Notify(method string, params any) error {
+	_, err := c.send(method, params, nil)
+	return err
+}
+
+// CallRPC is the generic counterpart of RPCClient.Call, returning the result as a new value of type R.
+func CallRPC[R any](c *RPCClient, method string, params any) (R, error) {
+	var result R
+	if err := c.Call(method, params, &result); err != nil {
+		var zero R
+		return zero, err
+	}
+
+	return result, nil
+}
+
+// send posts a request and decodes the response, if any.
+func (c *RPCClient) send(method string, params any, id json.RawMessage) (rpcResponse, error) {
+	req := struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Method  string          `json:"method"`
+		Params  any             `json:"params,omitempty"`
+		ID      json.RawMessage `json:"id,omitempty"`
+	}{JSONRPC: "2.0", Method: method, Params: params, ID: id}
+
+	response, statusCode, err := c.tools.pushJSON(c.url, req, c.client...)
+	if err != nil {
+		return rpcResponse{}, err
+	}
+	defer response.Body.Close()
+
+	// the body of any other status is not a JSON-RPC response, and may not be JSON at all
+	if statusCode != http.StatusOK && statusCode != http.StatusNoContent {
+		return rpcResponse{}, fmt.Errorf("json-rpc server answered with status %d", statusCode)
+	}
+
+	var resp rpcResponse
+	if err := json.NewDecoder(response.Body).Decode(&resp); err != nil && !errors.Is(err, io.EOF) {
+		return rpcResponse{}, fmt.Errorf("error decoding response body: %w", err)
+	}
+	if id != nil && resp.Error == nil && resp.Result == nil {
+		return rpcResponse{}, errors.New("json-rpc response has neither a result nor an error")
+	}
+
+	return resp, nil
+}
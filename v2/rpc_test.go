@@ -0,0 +1,168 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type rpcAddParams struct {
+	A int `json:"a"`
+	B int `json:"b" validate:"max=100"`
+}
+
+func newTestRPCServer(t *Tools, notified *atomic.Int32) *RPCServer {
+	s := t.NewRPCServer()
+	RegisterRPC(s, "add", func(ctx context.Context, p rpcAddParams) (int, error) {
+		return p.A + p.B, nil
+	})
+	RegisterRPC(s, "fail", func(ctx context.Context, p struct{}) (any, error) {
+		return nil, &RPCError{Code: 42, Message: "custom", Data: "details"}
+	})
+	RegisterRPC(s, "boom", func(ctx context.Context, p []any) (any, error) {
+		return nil, errors.New("boom")
+	})
+	RegisterRPC(s, "stale", func(ctx context.Context, p []any) (any, error) {
+		return nil, fmt.Errorf("version 2: %w", ErrPreconditionFailed)
+	})
+	RegisterRPC(s, "log", func(ctx context.Context, p []string) (any, error) {
+		notified.Add(1)
+		return nil, nil
+	})
+
+	return s
+}
+
+var rpcServerTests = []struct {
+	name     string
+	body     string
+	status   int
+	expected string
+}{
+	{name: "call", body: `{"jsonrpc":"2.0","method":"add","params":{"a":1,"b":2},"id":1}`, status: http.StatusOK,
+		expected: `{"jsonrpc":"2.0","result":3,"id":1}`},
+	{name: "string id", body: `{"jsonrpc":"2.0","method":"add","params":{"a":1,"b":2},"id":"x"}`, status: http.StatusOK,
+		expected: `{"jsonrpc":"2.0","result":3,"id":"x"}`},
+	{name: "parse error", body: `{"jsonrpc":"2.0",`, status: http.StatusOK,
+		expected: `"error":{"code":-32700,`},
+	{name: "empty body", body: ``, status: http.StatusOK,
+		expected: `"error":{"code":-32700,`},
+	{name: "invalid request", body: `{"jsonrpc":"1.0","method":"add","id":1}`, status: http.StatusOK,
+		expected: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"request must be a JSON-RPC 2.0 request object"},"id":1}`},
+	{name: "invalid id", body: `{"jsonrpc":"2.0","method":"add","id":{}}`, status: http.StatusOK,
+		expected: `"error":{"code":-32600,"message":"id must be a string, a number or null"},"id":null}`},
+	{name: "unknown method", body: `{"jsonrpc":"2.0","method":"nope","id":1}`, status: http.StatusOK,
+		expected: `{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found"},"id":1}`},
+	{name: "wrong param type", body: `{"jsonrpc":"2.0","method":"add","params":{"a":"1"},"id":1}`, status: http.StatusOK,
+		expected: `"error":{"code":-32602,"message":"Invalid params"`},
+	{name: "unknown param", body: `{"jsonrpc":"2.0","method":"add","params":{"c":1},"id":1}`, status: http.StatusOK,
+		expected: `"error":{"code":-32602,"message":"Invalid params"`},
+	{name: "invalid param value", body: `{"jsonrpc":"2.0","method":"add","params":{"b":101},"id":1}`, status: http.StatusOK,
+		expected: `"error":{"code":-32602,"message":"Invalid params","data":[{"field":"b","rule":"max"`},
+	{name: "custom error", body: `{"jsonrpc":"2.0","method":"fail","id":1}`, status: http.StatusOK,
+		expected: `{"jsonrpc":"2.0","error":{"code":42,"message":"custom","data":"details"},"id":1}`},
+	{name: "method error", body: `{"jsonrpc":"2.0","method":"boom","params":[],"id":1}`, status: http.StatusOK,
+		expected: `{"jsonrpc":"2.0","error":{"code":-32000,"message":"Server error"},"id":1}`},
+	{name: "method status error", body: `{"jsonrpc":"2.0","method":"stale","params":[],"id":1}`, status: http.StatusOK,
+		expected: `{"jsonrpc":"2.0","error":{"code":-32000,"message":"version 2: precondition failed"},"id":1}`},
+	{name: "notification", body: `{"jsonrpc":"2.0","method":"log","params":["a"]}`, status: http.StatusNoContent, expected: ``},
+	{name: "batch", body: `[{"jsonrpc":"2.0","method":"add","params":{"a":1,"b":1},"id":1},{"jsonrpc":"2.0","method":"log","params":[]},1]`, status: http.StatusOK,
+		expected: `[{"jsonrpc":"2.0","result":2,"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"request must be a JSON-RPC request object"},"id":null}]`},
+	{name: "batch of notifications", body: `[{"jsonrpc":"2.0","method":"log"}]`, status: http.StatusNoContent, expected: ``},
+	{name: "empty batch", body: `[]`, status: http.StatusOK,
+		expected: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"batch must be a non-empty array"},"id":null}`},
+}
+
+func TestRPCServer(t *testing.T) {
+	testTools := Tools{ValidateJSON: true}
+	var notified atomic.Int32
+	s := newTestRPCServer(&testTools, &notified)
+	var errorLog bytes.Buffer
+	s.ErrorLog = log.New(&errorLog, "", 0)
+
+	for _, e := range rpcServerTests {
+		t.Run(e.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/rpc", strings.NewReader(e.body))
+			rr := httptest.NewRecorder()
+			s.ServeHTTP(rr, req)
+
+			assert.Equal(t, e.status, rr.Code)
+			if strings.HasPrefix(e.expected, `"`) {
+				assert.Contains(t, rr.Body.String(), e.expected)
+			} else {
+				assert.Equal(t, e.expected, rr.Body.String())
+			}
+		})
+	}
+
+	assert.Equal(t, int32(3), notified.Load())
+	assert.Equal(t, "json-rpc: method boom: boom\n", errorLog.String())
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest("GET", "/rpc", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestRPCClient(t *testing.T) {
+	var testTools Tools
+	var notified atomic.Int32
+	server := httptest.NewServer(newTestRPCServer(&testTools, &notified))
+	defer server.Close()
+
+	client := testTools.NewRPCClient(server.URL)
+
+	sum, err := CallRPC[int](client, "add", rpcAddParams{A: 2, B: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, sum)
+
+	var rpcErr *RPCError
+	err = client.Call("fail", nil, nil)
+	if assert.ErrorAs(t, err, &rpcErr) {
+		assert.Equal(t, 42, rpcErr.Code)
+	}
+
+	_, err = CallRPC[int](client, "nope", nil)
+	if assert.ErrorAs(t, err, &rpcErr) {
+		assert.Equal(t, RPCMethodNotFound, rpcErr.Code)
+	}
+
+	assert.NoError(t, client.Notify("log", []string{"a"}))
+	assert.Equal(t, int32(1), notified.Load())
+}
+
+func TestRPCClient_Status(t *testing.T) {
+	testTools := Tools{HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+		return &http.Response{StatusCode: http.StatusBadGateway, Body: io.NopCloser(strings.NewReader(`<html>Bad Gateway</html>`)), Header: make(http.Header)}
+	})}
+	client := testTools.NewRPCClient("http://example.com/rpc")
+
+	// the status is reported, rather than the body failing to decode
+	_, err := CallRPC[int](client, "add", rpcAddParams{A: 2, B: 3})
+	assert.EqualError(t, err, "json-rpc server answered with status 502")
+}
+
+func TestRPCServer_NoErrorLog(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	var testTools Tools
+	var notified atomic.Int32
+	s := newTestRPCServer(&testTools, &notified)
+
+	rr := httptest.NewRecorder()
+	s.ServeHTTP(rr, httptest.NewRequest("POST", "/rpc", strings.NewReader(`{"jsonrpc":"2.0","method":"boom","id":1}`)))
+	assert.Contains(t, rr.Body.String(), `"Server error"`)
+	assert.Empty(t, logged.String())
+}
@@ -0,0 +1,876 @@
+package toolkit
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// draft202012 is the meta-schema URI of JSON Schema draft 2020-12.
+const draft202012 = "https://json-schema.org/draft/2020-12/schema"
+
+// maxSchemaDepth bounds how deeply $ref may recurse for a single instance location.
+const maxSchemaDepth = 256
+
+// unsupportedKeywords are draft 2020-12 keywords this package does not implement. A schema that
+// uses them is rejected when it is loaded, rather than silently accepting everything.
+var unsupportedKeywords = []string{"$dynamicRef", "$dynamicAnchor", "$recursiveRef", "unevaluatedProperties", "unevaluatedItems"}
+
+// Schema is a JSON Schema, draft 2020-12, used to validate JSON documents. It supports the
+// applicator and validation vocabularies (type, enum, const, the numeric, string, array and
+// object keywords, allOf, anyOf, oneOf, not, if/then/else and the dependent keywords), $defs and
+// $ref to JSON pointers within the same or another file, and the date-time, date, time, email,
+// uuid, uri, ipv4 and ipv6 formats. Patterns use Go's regexp syntax.
+type Schema struct {
+	name string
+	fsys fs.FS
+	docs map[string]any
+
+	mu      sync.Mutex
+	regexps map[string]*regexp.Regexp
+}
+
+// LoadSchema loads the schema in the file name of fsys, along with every other file its $ref
+// keywords refer to. References are resolved relative to the file they appear in.
+func LoadSchema(fsys fs.FS, name string) (*Schema, error) {
+	s := &Schema{name: path.Clean(name), fsys: fsys, docs: make(map[string]any)}
+	if err := s.load(s.name); err != nil {
+		return nil, err
+	}
+	if err := s.resolveRefs(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NewSchema parses a schema that is held in memory. It may only refer to itself with $ref, and is
+// checked as LoadSchema checks a file.
+func NewSchema(data []byte) (*Schema, error) {
+	doc, err := decodeGeneric(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing schema: %w", err)
+	}
+
+	s := &Schema{docs: map[string]any{"": doc}}
+	if err := checkDraft("", doc); err != nil {
+		return nil, err
+	}
+	if err := s.check("", doc); err != nil {
+		return nil, err
+	}
+	if err := s.resolveRefs(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load reads and checks the schema file name, and then every file it refers to.
+func (s *Schema) load(name string) error {
+	if _, ok := s.docs[name]; ok {
+		return nil
+	}
+
+	data, err := fs.ReadFile(s.fsys, name)
+	if err != nil {
+		return fmt.Errorf("error loading schema: %w", err)
+	}
+	doc, err := decodeGeneric(data)
+	if err != nil {
+		return fmt.Errorf("error parsing schema %s: %w", name, err)
+	}
+	if err := checkDraft(name, doc); err != nil {
+		return err
+	}
+
+	s.docs[name] = doc
+	return s.check(name, doc)
+}
+
+// checkDraft rejects a schema document whose $schema is not draft 2020-12.
+func checkDraft(name string, doc any) error {
+	if m, ok := doc.(map[string]any); ok {
+		if uri, ok := m["$schema"].(string); ok && strings.TrimSuffix(uri, "#") != draft202012 {
+			return fmt.Errorf("schema %s: unsupported $schema %q, only draft 2020-12 is supported", name, uri)
+		}
+	}
+
+	return nil
+}
+
+// check rejects unsupported keywords in doc and loads or verifies every $ref in it.
+func (s *Schema) check(name string, doc any) error {
+	switch node := doc.(type) {
+	case map[string]any:
+		for _, kw := range unsupportedKeywords {
+			if _, ok := node[kw]; ok {
+				return fmt.Errorf("schema %s: keyword %s is not supported", name, kw)
+			}
+		}
+		if ref, ok := node["$ref"].(string); ok {
+			file, _, _ := strings.Cut(ref, "#")
+			if file != "" {
+				if s.fsys == nil {
+					return fmt.Errorf("schema: cannot resolve $ref %q without a file system", ref)
+				}
+				if err := s.load(path.Join(path.Dir(name), file)); err != nil {
+					return err
+				}
+			}
+		}
+		for kw, v := range node {
+			if isDataKeyword(kw) {
+				continue
+			}
+			if err := s.check(name, v); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, v := range node {
+			if err := s.check(name, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isDataKeyword reports whether the value of keyword kw is instance data rather than a schema.
+func isDataKeyword(kw string) bool {
+	switch kw {
+	case "enum", "const", "default", "examples":
+		return true
+	}
+
+	return false
+}
+
+// resolveRefs resolves every $ref in the loaded documents, once they are all loaded, so that a
+// reference to a missing location, or to an $anchor or $id fragment, which are not supported,
+// fails when the schema is loaded rather than when a document is validated.
+func (s *Schema) resolveRefs() error {
+	var walk func(name string, doc any) error
+	walk = func(name string, doc any) error {
+		switch node := doc.(type) {
+		case map[string]any:
+			if ref, ok := node["$ref"].(string); ok {
+				if _, _, err := s.resolve(name, ref); err != nil {
+					return fmt.Errorf("schema %s: %w", name, err)
+				}
+			}
+			for kw, v := range node {
+				if isDataKeyword(kw) {
+					continue
+				}
+				if err := walk(name, v); err != nil {
+					return err
+				}
+			}
+		case []any:
+			for _, v := range node {
+				if err := walk(name, v); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	names := make([]string, 0, len(s.docs))
+	for name := range s.docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := walk(name, s.docs[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SchemaError describes one way in which a document does not match a schema. InstancePath is a
+// JSON Pointer to the offending value, and Keyword is the schema keyword that failed.
+type SchemaError struct {
+	InstancePath string `json:"instance_path"`
+	Keyword      string `json:"keyword"`
+	Message      string `json:"message"`
+}
+
+// SchemaErrors is returned when a document does not match a schema, and lists every violation.
+type SchemaErrors []SchemaError
+
+func (e SchemaErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, se := range e {
+		p := se.InstancePath
+		if p == "" {
+			p = "/"
+		}
+		msgs = append(msgs, p+": "+se.Message)
+	}
+
+	return "body does not match the schema: " + strings.Join(msgs, "; ")
+}
+
+func (e SchemaErrors) Unwrap() error   { return ErrSchemaValidation }
+func (e SchemaErrors) HTTPStatus() int { return http.StatusUnprocessableEntity }
+
+// ProblemExtensions lists every violation under the "errors" member.
+func (e SchemaErrors) ProblemExtensions() map[string]any {
+	return map[string]any{"errors": []SchemaError(e)}
+}
+
+// Validate checks the JSON document data against the schema. If it does not match, the returned
+// error is a SchemaErrors value listing every violation.
+func (s *Schema) Validate(data []byte) error {
+	instance, err := decodeGeneric(data)
+	if err != nil {
+		return fmt.Errorf("error parsing document: %w", err)
+	}
+
+	return s.ValidateValue(instance)
+}
+
+// ValidateValue is like Validate, but takes a document that has already been decoded into maps,
+// slices and json.Number or float64 values.
+func (s *Schema) ValidateValue(instance any) error {
+	var errs SchemaErrors
+	s.validate(s.name, s.docs[s.name], instance, "", 0, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// ReadJSONSchema reads a JSON request body like ReadJSON and validates it against schema before
+// decoding it into data. If data is nil, the body is only validated. A body that does not match
+// the schema results in SchemaErrors, which ErrorJSON reports as 422 Unprocessable Entity.
+func (t *Tools) ReadJSONSchema(w http.ResponseWriter, r *http.Request, schema *Schema, data any) error {
+	body, err := t.readJSONBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	// check the syntax and structural limits, and that there is a single value
+	var raw json.RawMessage
+	if err := t.decodeJSON(body, &raw); err != nil {
+		return err
+	}
+
+	if err := schema.Validate(raw); err != nil {
+		return err
+	}
+
+	if data == nil {
+		return nil
+	}
+
+	return t.decodeJSON(body, data)
+}
+
+// validate appends to errs every violation of schema, from document doc, by instance, found at instancePath.
+func (s *Schema) validate(doc string, schema any, instance any, instancePath string, depth int, errs *SchemaErrors) {
+	fail := func(keyword, format string, args ...any) {
+		*errs = append(*errs, SchemaError{InstancePath: instancePath, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+
+	sch, ok := schema.(map[string]any)
+	if !ok {
+		if allowed, isBool := schema.(bool); isBool && !allowed {
+			fail("false", "no value is allowed here")
+		}
+		return
+	}
+
+	if n, ok := numberText(instance); ok && !boundedNumber(n) {
+		fail("type", "number has too many digits or too large an exponent")
+		return
+	}
+
+	if ref, ok := sch["$ref"].(string); ok {
+		if depth >= maxSchemaDepth {
+			fail("$ref", "schema references recurse too deeply")
+			return
+		}
+		refDoc, target, err := s.resolve(doc, ref)
+		if err != nil {
+			fail("$ref", "%s", err.Error())
+		} else {
+			s.validate(refDoc, target, instance, instancePath, depth+1, errs)
+		}
+	}
+
+	if types, ok := sch["type"]; ok {
+		var names []string
+		switch v := types.(type) {
+		case string:
+			names = []string{v}
+		case []any:
+			for _, n := range v {
+				if name, ok := n.(string); ok {
+					names = append(names, name)
+				}
+			}
+		}
+		matched := false
+		for _, name := range names {
+			if hasJSONType(instance, name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("type", "must be of type %s", strings.Join(names, " or "))
+			return
+		}
+	}
+
+	if enum, ok := sch["enum"].([]any); ok {
+		found := false
+		for _, v := range enum {
+			if jsonEqual(normalizeJSON(instance), normalizeJSON(v)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("enum", "must be one of the allowed values")
+		}
+	}
+
+	if c, ok := sch["const"]; ok && !jsonEqual(normalizeJSON(instance), normalizeJSON(c)) {
+		fail("const", "must be equal to the constant value")
+	}
+
+	s.validateSubschemas(doc, sch, instance, instancePath, depth, errs, fail)
+
+	switch v := instance.(type) {
+	case json.Number, float64:
+		n, _ := numberText(v)
+		s.validateNumber(sch, n, fail)
+	case string:
+		s.validateString(sch, v, fail)
+	case []any:
+		s.validateArray(doc, sch, v, instancePath, depth, errs, fail)
+	case map[string]any:
+		s.validateObject(doc, sch, v, instancePath, depth, errs, fail)
+	}
+}
+
+// validateSubschemas applies the in-place applicators: allOf, anyOf, oneOf, not and if/then/else.
+func (s *Schema) validateSubschemas(doc string, sch map[string]any, instance any, instancePath string, depth int, errs *SchemaErrors, fail func(string, string, ...any)) {
+	matches := func(sub any) bool {
+		var subErrs SchemaErrors
+		s.validate(doc, sub, instance, instancePath, depth, &subErrs)
+		return len(subErrs) == 0
+	}
+
+	if all, ok := sch["allOf"].([]any); ok {
+		for _, sub := range all {
+			s.validate(doc, sub, instance, instancePath, depth, errs)
+		}
+	}
+
+	if anyOf, ok := sch["anyOf"].([]any); ok {
+		found := false
+		for _, sub := range anyOf {
+			if matches(sub) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("anyOf", "must match at least one of the schemas in anyOf")
+		}
+	}
+
+	if oneOf, ok := sch["oneOf"].([]any); ok {
+		n := 0
+		for _, sub := range oneOf {
+			if matches(sub) {
+				n++
+			}
+		}
+		if n != 1 {
+			fail("oneOf", "must match exactly one of the schemas in oneOf, but matches %d", n)
+		}
+	}
+
+	if not, ok := sch["not"]; ok && matches(not) {
+		fail("not", "must not match the schema in not")
+	}
+
+	if cond, ok := sch["if"]; ok {
+		if matches(cond) {
+			if then, ok := sch["then"]; ok {
+				s.validate(doc, then, instance, instancePath, depth, errs)
+			}
+		} else if els, ok := sch["else"]; ok {
+			s.validate(doc, els, instance, instancePath, depth, errs)
+		}
+	}
+}
+
+// validateNumber applies the numeric keywords to n, the text of a number small enough to compare.
+func (s *Schema) validateNumber(sch map[string]any, n string, fail func(string, string, ...any)) {
+	// compare returns how n compares to the number keyword holds, if it holds one
+	compare := func(keyword string) (string, int, bool) {
+		m, ok := numberText(sch[keyword])
+		if !ok {
+			return "", 0, false
+		}
+		c, ok := compareNumbers(n, m)
+		return m, c, ok
+	}
+
+	if m, ok := numberText(sch["multipleOf"]); ok {
+		if rn, rm := numberRat(n), numberRat(m); rn != nil && rm != nil && rm.Sign() > 0 && !new(big.Rat).Quo(rn, rm).IsInt() {
+			fail("multipleOf", "must be a multiple of %s", m)
+		}
+	}
+	if m, c, ok := compare("minimum"); ok && c < 0 {
+		fail("minimum", "must be at least %s", m)
+	}
+	if m, c, ok := compare("exclusiveMinimum"); ok && c <= 0 {
+		fail("exclusiveMinimum", "must be greater than %s", m)
+	}
+	if m, c, ok := compare("maximum"); ok && c > 0 {
+		fail("maximum", "must be at most %s", m)
+	}
+	if m, c, ok := compare("exclusiveMaximum"); ok && c >= 0 {
+		fail("exclusiveMaximum", "must be less than %s", m)
+	}
+}
+
+// validateString applies the string keywords.
+func (s *Schema) validateString(sch map[string]any, v string, fail func(string, string, ...any)) {
+	length := utf8.RuneCountInString(v)
+	if n, ok := schemaInt(sch, "minLength"); ok && length < n {
+		fail("minLength", "must be at least %d characters long", n)
+	}
+	if n, ok := schemaInt(sch, "maxLength"); ok && length > n {
+		fail("maxLength", "must be at most %d characters long", n)
+	}
+
+	if pattern, ok := sch["pattern"].(string); ok {
+		re, err := s.regexp(pattern)
+		if err != nil {
+			fail("pattern", "schema pattern %q is invalid", pattern)
+		} else if !re.MatchString(v) {
+			fail("pattern", "must match the pattern %q", pattern)
+		}
+	}
+
+	if format, ok := sch["format"].(string); ok && !validFormat(format, v) {
+		fail("format", "must be a valid %s", format)
+	}
+}
+
+// validateArray applies the array keywords.
+func (s *Schema) validateArray(doc string, sch map[string]any, v []any, instancePath string, depth int, errs *SchemaErrors, fail func(string, string, ...any)) {
+	if n, ok := schemaInt(sch, "minItems"); ok && len(v) < n {
+		fail("minItems", "must have at least %d items", n)
+	}
+	if n, ok := schemaInt(sch, "maxItems"); ok && len(v) > n {
+		fail("maxItems", "must have at most %d items", n)
+	}
+
+	if unique, _ := sch["uniqueItems"].(bool); unique {
+	outer:
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				if jsonEqual(normalizeJSON(v[i]), normalizeJSON(v[j])) {
+					fail("uniqueItems", "must not contain duplicate items, but items %d and %d are equal", i, j)
+					break outer
+				}
+			}
+		}
+	}
+
+	prefix, _ := sch["prefixItems"].([]any)
+	for i, sub := range prefix {
+		if i >= len(v) {
+			break
+		}
+		s.validate(doc, sub, v[i], instancePath+"/"+strconv.Itoa(i), depth, errs)
+	}
+	if items, ok := sch["items"]; ok {
+		for i := len(prefix); i < len(v); i++ {
+			s.validate(doc, items, v[i], instancePath+"/"+strconv.Itoa(i), depth, errs)
+		}
+	}
+
+	if contains, ok := sch["contains"]; ok {
+		n := 0
+		for i := range v {
+			var subErrs SchemaErrors
+			s.validate(doc, contains, v[i], instancePath+"/"+strconv.Itoa(i), depth, &subErrs)
+			if len(subErrs) == 0 {
+				n++
+			}
+		}
+
+		minContains := 1
+		if m, ok := schemaInt(sch, "minContains"); ok {
+			minContains = m
+		}
+		if n < minContains {
+			fail("contains", "must contain at least %d matching items", minContains)
+		}
+		if m, ok := schemaInt(sch, "maxContains"); ok && n > m {
+			fail("maxContains", "must contain at most %d matching items", m)
+		}
+	}
+}
+
+// validateObject applies the object keywords.
+func (s *Schema) validateObject(doc string, sch map[string]any, v map[string]any, instancePath string, depth int, errs *SchemaErrors, fail func(string, string, ...any)) {
+	if n, ok := schemaInt(sch, "minProperties"); ok && len(v) < n {
+		fail("minProperties", "must have at least %d properties", n)
+	}
+	if n, ok := schemaInt(sch, "maxProperties"); ok && len(v) > n {
+		fail("maxProperties", "must have at most %d properties", n)
+	}
+
+	if required, ok := sch["required"].([]any); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, present := v[name]; !present {
+					fail("required", "missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	if deps, ok := sch["dependentRequired"].(map[string]any); ok {
+		for prop, list := range deps {
+			if _, present := v[prop]; !present {
+				continue
+			}
+			names, _ := list.([]any)
+			for _, r := range names {
+				if name, ok := r.(string); ok {
+					if _, present := v[name]; !present {
+						fail("dependentRequired", "property %q is required when %q is present", name, prop)
+					}
+				}
+			}
+		}
+	}
+
+	if deps, ok := sch["dependentSchemas"].(map[string]any); ok {
+		for prop, sub := range deps {
+			if _, present := v[prop]; present {
+				s.validate(doc, sub, v, instancePath, depth, errs)
+			}
+		}
+	}
+
+	// visit the properties in a stable order, so that errors are reported consistently
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	properties, _ := sch["properties"].(map[string]any)
+	patterns, _ := sch["patternProperties"].(map[string]any)
+	additional, hasAdditional := sch["additionalProperties"]
+	names, hasNames := sch["propertyNames"]
+
+	for _, k := range keys {
+		childPath := instancePath + "/" + escapePointer(k)
+
+		if hasNames {
+			var subErrs SchemaErrors
+			s.validate(doc, names, k, childPath, depth, &subErrs)
+			if len(subErrs) > 0 {
+				fail("propertyNames", "property name %q is not allowed", k)
+			}
+		}
+
+		evaluated := false
+		if sub, ok := properties[k]; ok {
+			evaluated = true
+			s.validate(doc, sub, v[k], childPath, depth, errs)
+		}
+		for pattern, sub := range patterns {
+			re, err := s.regexp(pattern)
+			if err != nil || !re.MatchString(k) {
+				continue
+			}
+			evaluated = true
+			s.validate(doc, sub, v[k], childPath, depth, errs)
+		}
+
+		if !evaluated && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				*errs = append(*errs, SchemaError{InstancePath: childPath, Keyword: "additionalProperties", Message: fmt.Sprintf("property %q is not allowed", k)})
+				continue
+			}
+			s.validate(doc, additional, v[k], childPath, depth, errs)
+		}
+	}
+}
+
+// resolve finds the schema a $ref in document doc refers to, returning the document it is in.
+func (s *Schema) resolve(doc, ref string) (string, any, error) {
+	file, fragment, _ := strings.Cut(ref, "#")
+	if file != "" {
+		doc = path.Join(path.Dir(doc), file)
+	}
+
+	node, ok := s.docs[doc]
+	if !ok {
+		return "", nil, fmt.Errorf("cannot resolve $ref %q", ref)
+	}
+
+	fragment, err := url.PathUnescape(fragment)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot resolve $ref %q", ref)
+	}
+	tokens, err := parsePointer(fragment)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot resolve $ref %q", ref)
+	}
+
+	for _, tok := range tokens {
+		switch n := node.(type) {
+		case map[string]any:
+			node, ok = n[tok]
+		case []any:
+			var i int
+			i, ok = arrayIndex(tok, len(n), false)
+			if ok {
+				node = n[i]
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return "", nil, fmt.Errorf("cannot resolve $ref %q", ref)
+		}
+	}
+
+	return doc, node, nil
+}
+
+// regexp compiles and caches a pattern.
+func (s *Schema) regexp(pattern string) (*regexp.Regexp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if re, ok := s.regexps[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if s.regexps == nil {
+		s.regexps = make(map[string]*regexp.Regexp)
+	}
+	s.regexps[pattern] = re
+
+	return re, nil
+}
+
+// hasJSONType reports whether v is of the JSON Schema type name.
+func hasJSONType(v any, name string) bool {
+	switch name {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "number":
+		_, ok := numberText(v)
+		return ok
+	case "integer":
+		n, ok := numberText(v)
+		if !ok {
+			return false
+		}
+		if !strings.ContainsAny(n, ".eE") {
+			return true
+		}
+		r := numberRat(n)
+		return r != nil && r.IsInt()
+	}
+
+	return false
+}
+
+// Limits on the JSON numbers compared exactly. Exact comparison uses big.Rat, whose cost grows
+// with the exponent, so a number beyond these limits is refused before one is built.
+const (
+	maxNumberLen      = 1000 // characters
+	maxNumberExponent = 1000 // magnitude of the written exponent
+)
+
+// numberText returns the text of v, a decoded JSON number, and whether v is a number.
+func numberText(v any) (string, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		return n.String(), true
+	case float64:
+		return strconv.FormatFloat(n, 'g', -1, 64), true
+	}
+
+	return "", false
+}
+
+// boundedNumber reports whether the JSON number n is within maxNumberLen and maxNumberExponent.
+func boundedNumber(n string) bool {
+	if len(n) > maxNumberLen {
+		return false
+	}
+	i := strings.IndexAny(n, "eE")
+	if i < 0 {
+		return true
+	}
+	exp, err := strconv.Atoi(n[i+1:])
+
+	return err == nil && exp >= -maxNumberExponent && exp <= maxNumberExponent
+}
+
+// numberRat returns the exact value of the JSON number n, or nil if it is malformed or not bounded.
+func numberRat(n string) *big.Rat {
+	if !boundedNumber(n) {
+		return nil
+	}
+	r, ok := new(big.Rat).SetString(n)
+	if !ok {
+		return nil
+	}
+
+	return r
+}
+
+// compareNumbers compares the JSON numbers a and b, returning -1, 0 or +1. Rounding to float64
+// keeps order, so numbers whose float64 values differ are compared as floats; big.Rat is only
+// used when they round to the same value. It reports false if a or b is malformed or too large.
+func compareNumbers(a, b string) (int, bool) {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil && fa != fb {
+		return cmp.Compare(fa, fb), true
+	}
+
+	ra, rb := numberRat(a), numberRat(b)
+	if ra == nil || rb == nil {
+		return 0, false
+	}
+
+	return ra.Cmp(rb), true
+}
+
+// normalizeJSON converts float64 values in v to json.Number, so that jsonEqual can compare
+// values decoded with and without UseNumber.
+func normalizeJSON(v any) any {
+	switch n := v.(type) {
+	case float64:
+		return json.Number(strconv.FormatFloat(n, 'g', -1, 64))
+	case map[string]any:
+		out := make(map[string]any, len(n))
+		for k, e := range n {
+			out[k] = normalizeJSON(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(n))
+		for i, e := range n {
+			out[i] = normalizeJSON(e)
+		}
+		return out
+	}
+
+	return v
+}
+
+// schemaInt returns the non-negative integer value of keyword in sch.
+func schemaInt(sch map[string]any, keyword string) (int, bool) {
+	n, ok := numberText(sch[keyword])
+	if !ok {
+		return 0, false
+	}
+	r := numberRat(n)
+	if r == nil || !r.IsInt() || r.Sign() < 0 || !r.Num().IsInt64() {
+		return 0, false
+	}
+
+	return int(r.Num().Int64()), true
+}
+
+// escapePointer escapes a reference token of a JSON Pointer.
+func escapePointer(tok string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(tok, "~", "~0"), "/", "~1")
+}
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validFormat reports whether v is valid for format. Unknown formats are always valid.
+func validFormat(format, v string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, v)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", v)
+		return err == nil
+	case "time":
+		_, err := time.Parse("15:04:05Z07:00", v)
+		if err != nil {
+			_, err = time.Parse("15:04:05.999999999Z07:00", v)
+		}
+		return err == nil
+	case "email":
+		addr, err := mail.ParseAddress(v)
+		return err == nil && addr.Address == v
+	case "uuid":
+		return uuidRegexp.MatchString(v)
+	case "uri":
+		u, err := url.Parse(v)
+		return err == nil && u.Scheme != ""
+	case "ipv4":
+		ip := net.ParseIP(v)
+		return ip != nil && ip.To4() != nil && !strings.Contains(v, ":")
+	case "ipv6":
+		ip := net.ParseIP(v)
+		return ip != nil && strings.Contains(v, ":")
+	}
+
+	return true
+}
@@ -0,0 +1,234 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var schemaFS = fstest.MapFS{
+	"schemas/user.json": {Data: []byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"required": ["name", "email"],
+		"properties": {
+			"name": {"type": "string", "minLength": 2, "maxLength": 10},
+			"email": {"type": "string", "format": "email"},
+			"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+			"role": {"enum": ["admin", "user"]},
+			"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true, "maxItems": 3},
+			"address": {"$ref": "common.json#/$defs/address"}
+		},
+		"additionalProperties": false
+	}`)},
+	"schemas/common.json": {Data: []byte(`{
+		"$defs": {
+			"address": {
+				"type": "object",
+				"required": ["city"],
+				"properties": {"city": {"type": "string"}, "zip": {"type": "string", "pattern": "^[0-9]{5}$"}}
+			}
+		}
+	}`)},
+	"schemas/old.json":        {Data: []byte(`{"$schema": "http://json-schema.org/draft-07/schema#"}`)},
+	"schemas/dynamic.json":    {Data: []byte(`{"unevaluatedProperties": false}`)},
+	"schemas/missingref.json": {Data: []byte(`{"$ref": "nowhere.json"}`)},
+	"schemas/anchorref.json":  {Data: []byte(`{"$ref": "common.json#address"}`)},
+	"schemas/badpointer.json": {Data: []byte(`{"properties": {"a": {"$ref": "#/$defs/missing"}}}`)},
+}
+
+var schemaTests = []struct {
+	name     string
+	json     string
+	expected []SchemaError
+}{
+	{name: "valid", json: `{"name":"Ann","email":"ann@example.com","age":30,"role":"admin","tags":["a","b"],"address":{"city":"Oslo","zip":"01234"}}`},
+	{name: "not an object", json: `[]`, expected: []SchemaError{{InstancePath: "", Keyword: "type"}}},
+	{name: "missing required", json: `{"name":"Ann"}`, expected: []SchemaError{{InstancePath: "", Keyword: "required"}}},
+	{name: "wrong type", json: `{"name":1,"email":"ann@example.com"}`, expected: []SchemaError{{InstancePath: "/name", Keyword: "type"}}},
+	{name: "too short", json: `{"name":"A","email":"ann@example.com"}`, expected: []SchemaError{{InstancePath: "/name", Keyword: "minLength"}}},
+	{name: "bad format", json: `{"name":"Ann","email":"nope"}`, expected: []SchemaError{{InstancePath: "/email", Keyword: "format"}}},
+	{name: "not an integer", json: `{"name":"Ann","email":"ann@example.com","age":1.5}`, expected: []SchemaError{{InstancePath: "/age", Keyword: "type"}}},
+	{name: "integer as float", json: `{"name":"Ann","email":"ann@example.com","age":2.0}`},
+	{name: "too large", json: `{"name":"Ann","email":"ann@example.com","age":150}`, expected: []SchemaError{{InstancePath: "/age", Keyword: "exclusiveMaximum"}}},
+	{name: "enum", json: `{"name":"Ann","email":"ann@example.com","role":"root"}`, expected: []SchemaError{{InstancePath: "/role", Keyword: "enum"}}},
+	{name: "array items", json: `{"name":"Ann","email":"ann@example.com","tags":["a",2,"c","b"]}`, expected: []SchemaError{
+		{InstancePath: "/tags", Keyword: "maxItems"},
+		{InstancePath: "/tags/1", Keyword: "type"},
+	}},
+	{name: "duplicate items", json: `{"name":"Ann","email":"ann@example.com","tags":["a","a"]}`, expected: []SchemaError{{InstancePath: "/tags", Keyword: "uniqueItems"}}},
+	{name: "additional property", json: `{"name":"Ann","email":"ann@example.com","admin":true}`, expected: []SchemaError{{InstancePath: "/admin", Keyword: "additionalProperties"}}},
+	{name: "ref in other file", json: `{"name":"Ann","email":"ann@example.com","address":{"zip":"1"}}`, expected: []SchemaError{
+		{InstancePath: "/address", Keyword: "required"},
+		{InstancePath: "/address/zip", Keyword: "pattern"},
+	}},
+	{name: "every violation", json: `{"name":"A","email":"x"}`, expected: []SchemaError{
+		{InstancePath: "/email", Keyword: "format"},
+		{InstancePath: "/name", Keyword: "minLength"},
+	}},
+}
+
+func TestSchema_Validate(t *testing.T) {
+	schema, err := LoadSchema(schemaFS, "schemas/user.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, e := range schemaTests {
+		t.Run(e.name, func(t *testing.T) {
+			err := schema.Validate([]byte(e.json))
+			if e.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorIs(t, err, ErrSchemaValidation)
+			var serrs SchemaErrors
+			if assert.ErrorAs(t, err, &serrs) {
+				var got []SchemaError
+				for _, se := range serrs {
+					got = append(got, SchemaError{InstancePath: se.InstancePath, Keyword: se.Keyword})
+				}
+				assert.Equal(t, e.expected, got)
+			}
+		})
+	}
+}
+
+var schemaKeywordTests = []struct {
+	name   string
+	schema string
+	valid  []string
+	bad    []string
+}{
+	{name: "anyOf", schema: `{"anyOf":[{"type":"string"},{"type":"null"}]}`, valid: []string{`"a"`, `null`}, bad: []string{`1`}},
+	{name: "oneOf", schema: `{"oneOf":[{"multipleOf":3},{"multipleOf":5}]}`, valid: []string{`3`, `10`}, bad: []string{`15`, `7`}},
+	{name: "not", schema: `{"not":{"type":"string"}}`, valid: []string{`1`}, bad: []string{`"a"`}},
+	{name: "if then else", schema: `{"if":{"properties":{"kind":{"const":"a"}}},"then":{"required":["a"]},"else":{"required":["b"]}}`,
+		valid: []string{`{"kind":"a","a":1}`, `{"kind":"b","b":1}`}, bad: []string{`{"kind":"a"}`, `{"kind":"b","a":1}`}},
+	{name: "prefixItems", schema: `{"prefixItems":[{"type":"string"}],"items":{"type":"integer"}}`, valid: []string{`["a",1,2]`}, bad: []string{`[1]`, `["a","b"]`}},
+	{name: "contains", schema: `{"contains":{"type":"integer"},"maxContains":1}`, valid: []string{`["a",1]`}, bad: []string{`["a"]`, `[1,2]`}},
+	{name: "dependentRequired", schema: `{"dependentRequired":{"card":["cvv"]}}`, valid: []string{`{}`, `{"card":1,"cvv":2}`}, bad: []string{`{"card":1}`}},
+	{name: "propertyNames", schema: `{"propertyNames":{"maxLength":3}}`, valid: []string{`{"abc":1}`}, bad: []string{`{"abcd":1}`}},
+	{name: "patternProperties", schema: `{"patternProperties":{"^x-":{"type":"string"}},"additionalProperties":false}`, valid: []string{`{"x-a":"b"}`}, bad: []string{`{"x-a":1}`, `{"y":"b"}`}},
+	{name: "recursive ref", schema: `{"$defs":{"node":{"type":"object","properties":{"next":{"$ref":"#/$defs/node"}}}},"$ref":"#/$defs/node"}`,
+		valid: []string{`{"next":{"next":{}}}`}, bad: []string{`{"next":{"next":1}}`}},
+	{name: "big numbers", schema: `{"maximum":9007199254740993}`, valid: []string{`9007199254740993`}, bad: []string{`9007199254740994`}},
+	{name: "false schema", schema: `{"properties":{"a":false}}`, valid: []string{`{}`}, bad: []string{`{"a":1}`}},
+}
+
+func TestSchema_Keywords(t *testing.T) {
+	for _, e := range schemaKeywordTests {
+		t.Run(e.name, func(t *testing.T) {
+			schema, err := NewSchema([]byte(e.schema))
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, v := range e.valid {
+				assert.NoError(t, schema.Validate([]byte(v)), v)
+			}
+			for _, v := range e.bad {
+				assert.ErrorIs(t, schema.Validate([]byte(v)), ErrSchemaValidation, v)
+			}
+		})
+	}
+}
+
+func TestLoadSchema_Errors(t *testing.T) {
+	for _, name := range []string{"schemas/missing.json", "schemas/old.json", "schemas/dynamic.json", "schemas/missingref.json", "schemas/anchorref.json", "schemas/badpointer.json"} {
+		_, err := LoadSchema(schemaFS, name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestNewSchema_Errors(t *testing.T) {
+	for _, schema := range []string{
+		`{"$schema": "http://json-schema.org/draft-07/schema#"}`,
+		`{"$defs": {"a": {"$anchor": "a"}}, "$ref": "#a"}`,
+		`{"$id": "https://example.com/s", "$ref": "https://example.com/s#/$defs/a"}`,
+		`{"items": {"$ref": "#/$defs/missing"}}`,
+		`{"$ref": "other.json"}`,
+	} {
+		_, err := NewSchema([]byte(schema))
+		assert.Error(t, err, schema)
+	}
+}
+
+func TestTools_ReadJSONSchema(t *testing.T) {
+	var testTools Tools
+	schema, _ := LoadSchema(schemaFS, "schemas/user.json")
+
+	type user struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"name":"Ann","email":"ann@example.com"}`))
+	var u user
+	assert.NoError(t, testTools.ReadJSONSchema(httptest.NewRecorder(), req, schema, &u))
+	assert.Equal(t, "Ann", u.Name)
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader(`{"name":"Ann"}`))
+	err := testTools.ReadJSONSchema(httptest.NewRecorder(), req, schema, nil)
+	assert.ErrorIs(t, err, ErrSchemaValidation)
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader(`{"name":`))
+	err = testTools.ReadJSONSchema(httptest.NewRecorder(), req, schema, nil)
+	assert.ErrorIs(t, err, ErrBadJSON)
+}
+
+func TestTools_ErrorJSON_SchemaErrors(t *testing.T) {
+	testTools := Tools{UseProblemDetails: true}
+	schema, _ := LoadSchema(schemaFS, "schemas/user.json")
+
+	err := schema.Validate([]byte(`{"name":"Ann"}`))
+	rr := httptest.NewRecorder()
+	assert.NoError(t, testTools.ErrorJSON(rr, err))
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+
+	var body struct {
+		Errors []SchemaError `json:"errors"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, []SchemaError{{InstancePath: "", Keyword: "required", Message: `missing required property "email"`}}, body.Errors)
+
+	assert.True(t, errors.Is(err, ErrSchemaValidation))
+}
+
+func TestSchema_PathologicalNumbers(t *testing.T) {
+	schema, err := NewSchema([]byte(`{"type":"array","items":{"type":"number","maximum":10,"multipleOf":0.5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	huge := "[" + strings.TrimSuffix(strings.Repeat("1e999999,", 50), ",") + "]"
+	start := time.Now()
+	err = schema.Validate([]byte(huge))
+	assert.Less(t, time.Since(start), time.Second)
+	var errs SchemaErrors
+	if assert.ErrorAs(t, err, &errs) {
+		assert.Equal(t, "type", errs[0].Keyword)
+	}
+
+	// numbers within the limits are still compared exactly
+	assert.NoError(t, schema.Validate([]byte(`[-1e300, 9.5, 1e0, 5e-1]`)))
+	assert.Error(t, schema.Validate([]byte(`[10.0000000000000000000000001]`)))
+
+	// distinct large numbers are never built as big.Rat values to compare them
+	unique, _ := NewSchema([]byte(`{"uniqueItems":true}`))
+	var distinct []string
+	for i := 1; i <= 50; i++ {
+		distinct = append(distinct, strconv.Itoa(i)+"e999999")
+	}
+	start = time.Now()
+	assert.NoError(t, unique.Validate([]byte("["+strings.Join(distinct, ",")+"]")))
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,215 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSSEHeartbeat is the heartbeat interval used when SSEOptions.Heartbeat is not set.
+const defaultSSEHeartbeat = 30 * time.Second
+
+// SSEEvent is one Server-Sent Event. Data is encoded as JSON, as WriteJSON would encode it.
+// ID, if set, is remembered by the client and sent back as Last-Event-ID when it reconnects.
+// Event names the event type, which defaults to "message" on the client. Retry, if set,
+// changes how long the client waits before reconnecting.
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  any
+	Retry time.Duration
+}
+
+// SSEBuffer holds recent events so that a reconnecting client can be sent those it missed.
+// Since returns the events after the one with the given ID, in order. If the ID is unknown,
+// for example because it has been evicted, it returns every event it holds.
+type SSEBuffer interface {
+	Since(lastEventID string) []SSEEvent
+}
+
+// SSEOptions configures a stream written by SSE.
+type SSEOptions struct {
+	Retry     time.Duration // reconnection delay sent to the client when the stream opens; 0 sends none
+	Heartbeat time.Duration // interval between keep-alive comments; 0 means 30 seconds, negative disables them
+	Buffer    SSEBuffer     // events replayed to a client that reconnects with Last-Event-ID; nil disables replay
+}
+
+// SSE answers r with a text/event-stream and writes every event received from events to it,
+// flushing after each one. When the request has a Last-Event-ID header and opts has a Buffer,
+// the events the client missed are sent first, and those then received again from events are
+// skipped. Comments are sent every Heartbeat to keep proxies from closing an idle connection.
+// SSE returns nil when events is closed or the request's context ends, for example because the
+// client went away, and an error if an event cannot be encoded or written.
+func (t *Tools) SSE(w http.ResponseWriter, r *http.Request, events <-chan SSEEvent, opts ...SSEOptions) error {
+	var opt SSEOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return fmt.Errorf("error starting event stream: %w", err)
+	}
+
+	if opt.Retry > 0 {
+		if err := writeSSE(w, rc, []byte("retry: "+strconv.FormatInt(opt.Retry.Milliseconds(), 10)+"\n\n")); err != nil {
+			return err
+		}
+	}
+
+	replayed := make(map[string]bool)
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" && opt.Buffer != nil {
+		for _, ev := range opt.Buffer.Since(lastID) {
+			if err := sendSSE(w, rc, ev); err != nil {
+				return err
+			}
+			if ev.ID != "" {
+				replayed[ev.ID] = true
+			}
+		}
+	}
+
+	heartbeat := opt.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = defaultSSEHeartbeat
+	}
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick:
+			if err := writeSSE(w, rc, []byte(": keep-alive\n\n")); err != nil {
+				return err
+			}
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if ev.ID != "" && replayed[ev.ID] {
+				delete(replayed, ev.ID)
+				continue
+			}
+			if err := sendSSE(w, rc, ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendSSE encodes and writes a single event.
+func sendSSE(w http.ResponseWriter, rc *http.ResponseController, ev SSEEvent) error {
+	frame, err := encodeSSE(ev)
+	if err != nil {
+		return err
+	}
+
+	return writeSSE(w, rc, frame)
+}
+
+// writeSSE writes a frame and flushes it to the client.
+func writeSSE(w http.ResponseWriter, rc *http.ResponseController, frame []byte) error {
+	if _, err := w.Write(frame); err != nil {
+		return err
+	}
+
+	return rc.Flush()
+}
+
+// encodeSSE returns the wire format of ev.
+func encodeSSE(ev SSEEvent) ([]byte, error) {
+	if strings.ContainsAny(ev.ID, "\r\n\x00") || strings.ContainsAny(ev.Event, "\r\n") {
+		return nil, errors.New("event ID and name must not contain line breaks")
+	}
+
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if ev.ID != "" {
+		buf.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Event != "" {
+		buf.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+	}
+	// encoding/json never produces raw line breaks, so the data fits on a single line
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+
+	return buf.Bytes(), nil
+}
+
+// SSEMemoryBuffer is an SSEBuffer that keeps the most recent events in memory. It is safe for
+// concurrent use, so a publisher can Add events while streams replay them.
+type SSEMemoryBuffer struct {
+	mu     sync.Mutex
+	size   int
+	events []SSEEvent
+	nextID uint64
+}
+
+// NewSSEMemoryBuffer returns a buffer holding at most size events.
+func NewSSEMemoryBuffer(size int) *SSEMemoryBuffer {
+	return &SSEMemoryBuffer{size: max(size, 1)}
+}
+
+// Add stores ev, evicting the oldest event if the buffer is full. An event without an ID is
+// given the next number in sequence. It returns the event as stored, to be sent to live streams.
+func (b *SSEMemoryBuffer) Add(ev SSEEvent) SSEEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	if ev.ID == "" {
+		ev.ID = strconv.FormatUint(b.nextID, 10)
+	}
+
+	if len(b.events) == b.size {
+		copy(b.events, b.events[1:])
+		b.events = b.events[:len(b.events)-1]
+	}
+	b.events = append(b.events, ev)
+
+	return ev
+}
+
+// Since implements SSEBuffer.
+func (b *SSEMemoryBuffer) Since(lastEventID string) []SSEEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	start := 0
+	for i, ev := range b.events {
+		if ev.ID == lastEventID {
+			start = i + 1
+			break
+		}
+	}
+
+	return append([]SSEEvent(nil), b.events[start:]...)
+}
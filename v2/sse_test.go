@@ -0,0 +1,104 @@
+package toolkit
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTools_SSE(t *testing.T) {
+	var testTools Tools
+
+	events := make(chan SSEEvent, 3)
+	events <- SSEEvent{ID: "1", Event: "update", Data: map[string]int{"count": 1}}
+	events <- SSEEvent{Data: "line\nbreak", Retry: 2 * time.Second}
+	close(events)
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	rr := httptest.NewRecorder()
+
+	err := testTools.SSE(rr, req, events, SSEOptions{Retry: 5 * time.Second, Heartbeat: -1})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, "retry: 5000\n\n"+
+		"id: 1\nevent: update\ndata: {\"count\":1}\n\n"+
+		"retry: 2000\ndata: \"line\\nbreak\"\n\n", rr.Body.String())
+}
+
+func TestTools_SSE_Heartbeat(t *testing.T) {
+	var testTools Tools
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/events", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	done := make(chan error)
+	go func() {
+		done <- testTools.SSE(rr, req, make(chan SSEEvent), SSEOptions{Heartbeat: 5 * time.Millisecond})
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("SSE did not return when the request context ended")
+	}
+	assert.True(t, strings.HasPrefix(rr.Body.String(), ": keep-alive\n\n"))
+}
+
+func TestTools_SSE_Replay(t *testing.T) {
+	var testTools Tools
+
+	buffer := NewSSEMemoryBuffer(3)
+	var stored []SSEEvent
+	for i := 0; i < 4; i++ {
+		stored = append(stored, buffer.Add(SSEEvent{Data: i}))
+	}
+	assert.Equal(t, "4", stored[3].ID)
+
+	// the live channel repeats an event already replayed from the buffer, then a new one
+	events := make(chan SSEEvent, 2)
+	events <- stored[3]
+	events <- buffer.Add(SSEEvent{Data: 4})
+	close(events)
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Last-Event-ID", "2")
+	rr := httptest.NewRecorder()
+
+	assert.NoError(t, testTools.SSE(rr, req, events, SSEOptions{Heartbeat: -1, Buffer: buffer}))
+	assert.Equal(t, "id: 3\ndata: 2\n\nid: 4\ndata: 3\n\nid: 5\ndata: 4\n\n", rr.Body.String())
+}
+
+func TestSSEMemoryBuffer_Since(t *testing.T) {
+	buffer := NewSSEMemoryBuffer(2)
+	for i := 0; i < 3; i++ {
+		buffer.Add(SSEEvent{Data: i})
+	}
+
+	assert.Len(t, buffer.Since("2"), 1)
+	assert.Len(t, buffer.Since("3"), 0)
+	// an evicted ID replays everything that is left
+	assert.Len(t, buffer.Since("1"), 2)
+}
+
+func TestTools_SSE_BadEvent(t *testing.T) {
+	var testTools Tools
+
+	events := make(chan SSEEvent, 1)
+	events <- SSEEvent{ID: "a\nb"}
+	close(events)
+
+	req := httptest.NewRequest("GET", "/events", nil)
+	assert.Error(t, testTools.SSE(httptest.NewRecorder(), req, events, SSEOptions{Heartbeat: -1}))
+}
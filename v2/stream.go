@@ -0,0 +1,216 @@
+package toolkit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+)
+
+// ndjsonContentType is the media type of newline delimited JSON (JSON Lines).
+const ndjsonContentType = "application/x-ndjson"
+
+// JSONRecord is a single record read from a newline delimited JSON body by ReadJSONStream.
+type JSONRecord struct {
+	Line int    // 1-based line number of the record in the body
+	Raw  []byte // the record exactly as received, without the trailing newline
+
+	tools *Tools
+}
+
+// Decode decodes the record into data with the same rules as ReadJSON: StrictJSON, the structural
+// limits, AllowUnknownFields and ValidateJSON all apply. Errors are returned as a *RecordError.
+func (rec *JSONRecord) Decode(data any) error {
+	if err := rec.tools.decodeJSON(rec.Raw, data); err != nil {
+		return &RecordError{Line: rec.Line, Err: err}
+	}
+
+	return nil
+}
+
+// RecordError reports a problem with one record of a newline delimited JSON body.
+type RecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *RecordError) Unwrap() error { return e.Err }
+
+// ProblemExtensions adds the line number to the members of the underlying error.
+func (e *RecordError) ProblemExtensions() map[string]any {
+	ext := map[string]any{}
+
+	var extender ProblemExtender
+	if errors.As(e.Err, &extender) {
+		for k, v := range extender.ProblemExtensions() {
+			ext[k] = v
+		}
+	}
+	ext["line"] = e.Line
+
+	return ext
+}
+
+// ReadJSONStream iterates over the records of a newline delimited JSON (application/x-ndjson)
+// request body, one line at a time, skipping blank lines. Each record may be at most MaxJSONSize
+// bytes, and the body as a whole at most MaxStreamSize bytes, both as received and decompressed.
+//
+// Each iteration yields either a record, which the caller decodes with JSONRecord.Decode, or an error
+// that ends the stream, such as a *RecordError wrapping a *BodyTooLargeError for an oversized line
+// or body.
+func (t *Tools) ReadJSONStream(w http.ResponseWriter, r *http.Request) iter.Seq2[*JSONRecord, error] {
+	return func(yield func(*JSONRecord, error) bool) {
+		maxBytes, maxStream := t.maxJSONBytes(), t.maxStreamBytes()
+		r.Body = http.MaxBytesReader(w, r.Body, maxStream)
+
+		body, err := t.decompressedBody(r, maxBytes)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer body.Close()
+
+		limited := &readErrRecorder{Reader: http.MaxBytesReader(w, io.NopCloser(body), maxStream)}
+		scanner := bufio.NewScanner(limited)
+		scanner.Buffer(make([]byte, 0, 64*1024), int(maxBytes)+1)
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			// a last line cut short by an error reading the body is not a record
+			if atEOF && limited.err != nil && bytes.IndexByte(data, '\n') < 0 {
+				return 0, nil, nil
+			}
+			return bufio.ScanLines(data, atEOF)
+		})
+
+		line := 0
+		for scanner.Scan() {
+			line++
+
+			raw := bytes.TrimSpace(scanner.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+			if int64(len(raw)) > maxBytes {
+				yield(nil, &RecordError{Line: line, Err: &BodyTooLargeError{Limit: maxBytes}})
+				return
+			}
+
+			rec := &JSONRecord{Line: line, Raw: bytes.Clone(raw), tools: t}
+			if !yield(rec, nil) {
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.Is(err, bufio.ErrTooLong) || decoderLimitError(err):
+				err = &BodyTooLargeError{Limit: maxBytes}
+			case errors.As(err, &maxBytesError):
+				err = &BodyTooLargeError{Limit: maxStream}
+			}
+			yield(nil, &RecordError{Line: line + 1, Err: err})
+		}
+	}
+}
+
+// readErrRecorder is a reader that remembers the first error other than io.EOF it returned.
+type readErrRecorder struct {
+	io.Reader
+	err error
+}
+
+func (r *readErrRecorder) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+
+	return n, err
+}
+
+// ReadJSONStreamAs is the generic counterpart of ReadJSONStream. It decodes every record into
+// a new value of type T. A record that fails to decode yields its *RecordError and iteration
+// continues with the next one; errors reading the body end the stream.
+func ReadJSONStreamAs[T any](t *Tools, w http.ResponseWriter, r *http.Request) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for rec, err := range t.ReadJSONStream(w, r) {
+			var data T
+			if err != nil {
+				yield(data, err)
+				return
+			}
+
+			if err := rec.Decode(&data); err != nil {
+				var zero T
+				if !yield(zero, err) {
+					return
+				}
+				continue
+			}
+
+			if !yield(data, nil) {
+				return
+			}
+		}
+	}
+}
+
+// WriteJSONStream writes records to the client as newline delimited JSON (application/x-ndjson),
+// flushing after each one so that clients see them as they are produced. Use StreamSeq or
+// StreamChan to adapt a typed iterator or a channel. Once the first record has been written the
+// status code cannot change, so an error part way through only ends the stream.
+func (t *Tools) WriteJSONStream(w http.ResponseWriter, status int, records iter.Seq[any], headers ...http.Header) error {
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(status)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+
+	for record := range records {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StreamSeq adapts a typed iterator for use with WriteJSONStream.
+func StreamSeq[T any](seq iter.Seq[T]) iter.Seq[any] {
+	return func(yield func(any) bool) {
+		for v := range seq {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// StreamChan adapts a channel for use with WriteJSONStream. The stream ends when ch is closed.
+func StreamChan[T any](ch <-chan T) iter.Seq[any] {
+	return func(yield func(any) bool) {
+		for v := range ch {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
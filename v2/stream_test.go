@@ -0,0 +1,121 @@
+package toolkit
+
+import (
+	"bufio"
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type streamRecord struct {
+	ID   int    `json:"id"`
+	Name string `json:"name" validate:"required"`
+}
+
+func TestTools_ReadJSONStream(t *testing.T) {
+	testTools := Tools{ValidateJSON: true}
+
+	body := "{\"id\":1,\"name\":\"a\"}\n\n{\"id\":2,\"name\":\"\"}\n{\"id\":\"x\"}\n{\"id\":4,\"name\":\"d\"}\n"
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+
+	var good []int
+	var badLines []int
+	for rec, err := range testTools.ReadJSONStream(httptest.NewRecorder(), req) {
+		assert.NoError(t, err)
+
+		var r streamRecord
+		if err := rec.Decode(&r); err != nil {
+			var recErr *RecordError
+			assert.True(t, errors.As(err, &recErr))
+			badLines = append(badLines, recErr.Line)
+			continue
+		}
+		good = append(good, r.ID)
+	}
+
+	assert.Equal(t, []int{1, 4}, good)
+	assert.Equal(t, []int{3, 4}, badLines)
+}
+
+func TestTools_ReadJSONStreamAs(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 30}
+
+	body := "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"this name is far too long\"}\n{\"id\":3,\"name\":\"c\"}\n"
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+
+	var ids []int
+	var streamErr error
+	for r, err := range ReadJSONStreamAs[streamRecord](&testTools, httptest.NewRecorder(), req) {
+		if err != nil {
+			streamErr = err
+			continue
+		}
+		ids = append(ids, r.ID)
+	}
+
+	assert.Equal(t, []int{1}, ids)
+	assert.ErrorIs(t, streamErr, ErrBodyTooLarge)
+
+	var recErr *RecordError
+	assert.True(t, errors.As(streamErr, &recErr))
+	assert.Equal(t, 2, recErr.Line)
+}
+
+func TestTools_ReadJSONStream_MaxStreamSize(t *testing.T) {
+	testTools := Tools{MaxStreamSize: 50}
+
+	body := strings.Repeat("{\"id\":1,\"name\":\"a\"}\n", 5)
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(body))
+
+	records := 0
+	var streamErr error
+	for _, err := range testTools.ReadJSONStream(httptest.NewRecorder(), req) {
+		if err != nil {
+			streamErr = err
+			continue
+		}
+		records++
+	}
+
+	assert.Equal(t, 2, records)
+	var tooLarge *BodyTooLargeError
+	if assert.ErrorAs(t, streamErr, &tooLarge) {
+		assert.Equal(t, int64(50), tooLarge.Limit)
+	}
+}
+
+func TestTools_WriteJSONStream(t *testing.T) {
+	var testTools Tools
+
+	var seq iter.Seq[streamRecord] = slices.Values([]streamRecord{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}})
+
+	rr := httptest.NewRecorder()
+	err := testTools.WriteJSONStream(rr, http.StatusOK, StreamSeq(seq))
+	assert.NoError(t, err)
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, "{\"id\":1,\"name\":\"a\"}\n{\"id\":2,\"name\":\"b\"}\n", rr.Body.String())
+
+	ch := make(chan int, 3)
+	ch <- 1
+	ch <- 2
+	ch <- 3
+	close(ch)
+
+	rr = httptest.NewRecorder()
+	err = testTools.WriteJSONStream(rr, http.StatusOK, StreamChan(ch))
+	assert.NoError(t, err)
+
+	var lines []string
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Equal(t, []string{"1", "2", "3"}, lines)
+}
@@ -2,7 +2,9 @@ package toolkit
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,7 +13,9 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -24,6 +28,31 @@ type Tools struct {
 	AllowedFileTypes   []string
 	MaxJSONSize        int
 	AllowUnknownFields bool
+	StrictJSON         bool              // when true, ReadJSON rejects duplicate keys and keys that only match a field case-insensitively
+	ValidateJSON       bool              // when true, ReadJSON runs Validate on the decoded data
+	MaxDepth           int               // maximum nesting depth of objects and arrays in a JSON body; 0 means no limit
+	MaxArrayLen        int               // maximum number of elements in any JSON array; 0 means no limit
+	MaxStringLen       int               // maximum length in bytes of any JSON string or key; 0 means no limit
+	MaxKeys            int               // maximum number of keys in any JSON object; 0 means no limit
+	MaxStreamSize      int64             // largest newline delimited JSON body ReadJSONStream reads, in bytes, after decompression; 0 means 64 MiB
+	Codecs             *CodecRegistry    // formats available to Write and Read; nil means DefaultCodecs
+	Compress           bool              // when true, responses are compressed if the client accepts it; only applies when w comes from WithRequest or Middleware, as the request's Accept-Encoding is needed
+	CompressMinSize    int               // smallest response body, in bytes, worth compressing; 0 means 1024
+	ContentEncodings   []ContentEncoding // encodings for responses and request bodies, in order of preference; nil means gzip, deflate and zstd
+	ETags              bool              // when true, successful responses written through WithRequest get a strong ETag
+	DefaultPageLimit   int               // page size used by ReadPageRequest when the client gives none; 0 means 20
+	MaxPageLimit       int               // largest page size ReadPageRequest allows; 0 means 100
+	CursorKey          []byte            // key used to sign pagination cursors
+	SparseFields       bool              // when true, WriteJSON prunes responses to the fields named in the request's fields query parameter
+	AllowedFields      string            // when set, WriteJSON prunes successful responses to these fields, in the same syntax as the fields parameter
+	Envelope           Envelope          // shapes WriteJSON and ErrorJSON payloads; nil means DefaultEnvelope
+	RequestIDHeader    string            // header carrying request IDs for WithRequest; empty means X-Request-ID
+	HTTPClient         *http.Client      // client for outbound requests; nil means a new default client
+	Retry              *RetryPolicy      // how outbound requests are retried; nil means they are not
+	CircuitBreaker     *CircuitBreaker   // per-host circuit breaker for outbound requests; nil means none
+	IdempotencyKeys    bool              // when true, PushJSONToRemote sends a generated Idempotency-Key
+	Clock              Clock             // time source for the HTTP client; nil means the real clock
+	UseProblemDetails  bool              // when true, ErrorJSON writes RFC 9457 problem details
 }
 
 // RandomString returns a string of random characters of length n,
@@ -39,6 +68,17 @@ func (t *Tools) RandomString(n int) string {
 	return string(s)
 }
 
+// randomID returns n random characters from the URL-safe base64 alphabet, for identifiers such as
+// request IDs and idempotency keys that must not be guessed.
+func randomID(n int) string {
+	b := make([]byte, (n*6+7)/8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("toolkit: reading random bytes: %v", err))
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)[:n]
+}
+
 // UploadedFile is a struct used to store information about an uploaded file.
 type UploadedFile struct {
 	NewFileName      string
@@ -78,7 +118,7 @@ func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) (
 
 	err = r.ParseMultipartForm(t.MaxFileSize)
 	if err != nil {
-		return nil, errors.New("the uploaded file is too big")
+		return nil, &FileTooLargeError{Limit: t.MaxFileSize, Err: err}
 	}
 
 	for _, fHeaders := range r.MultipartForm.File {
@@ -115,7 +155,7 @@ func (t *Tools) getUploadedFiles(
 	allowed := t.isAllowedFileType(fileType, t.AllowedFileTypes)
 
 	if !allowed {
-		return nil, errors.New("the uploaded file type is not permitted")
+		return nil, &FileTypeError{FileName: hdr.Filename, ContentType: fileType, AllowedTypes: t.AllowedFileTypes}
 	}
 
 	_, err = infile.Seek(0, 0)
@@ -195,13 +235,13 @@ func (t *Tools) CreateDirIfNotExist(pathDir string) error {
 // Slugify is a very simple slugification function.
 func (t *Tools) Slugify(s string) (string, error) {
 	if s == "" {
-		return "", errors.New("empty string not permited")
+		return "", ErrEmptySlugInput
 	}
 
 	var re = regexp.MustCompile(`[^a-z\d]+`)
 	slug := strings.Trim(re.ReplaceAllString(strings.ToLower(s), "-"), "-")
 	if len(slug) == 0 {
-		return "", errors.New("after removing special characters, slug is empty")
+		return "", ErrEmptySlug
 	}
 	return slug, nil
 }
@@ -224,14 +264,76 @@ type JSONResponse struct {
 
 // ReadJSON tries to read the body of a request and converts from JSON into a data variable.
 func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data any) error {
+	body, err := t.readJSONBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	return t.decodeJSON(body, data)
+}
+
+// maxJSONBytes returns MaxJSONSize, or the 1MB default if it is not set.
+func (t *Tools) maxJSONBytes() int64 {
 	maxBytes := 1024 * 1024 // 1MB
 	if t.MaxJSONSize != 0 {
 		maxBytes = t.MaxJSONSize
 	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	return int64(maxBytes)
+}
+
+// maxStreamBytes returns MaxStreamSize, or the 64MiB default if it is not set.
+func (t *Tools) maxStreamBytes() int64 {
+	if t.MaxStreamSize > 0 {
+		return t.MaxStreamSize
+	}
+
+	return 64 << 20
+}
+
+// readJSONBody reads the whole request body, undoing any Content-Encoding, and refuses to read
+// more than MaxJSONSize bytes. The limit applies to the decompressed body as well as to what was
+// received, so that a small compressed body cannot expand into a huge one.
+func (t *Tools) readJSONBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	maxBytes := t.maxJSONBytes()
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	reader, err := t.decompressedBody(r, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	body, err := io.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) || decoderLimitError(err) {
+			return nil, &BodyTooLargeError{Limit: maxBytes}
+		}
+		if reader.decoded() {
+			return nil, &BodyDecodeError{ContentType: r.Header.Get("Content-Encoding"), Err: err}
+		}
+		return nil, err
+	}
+
+	if int64(len(body)) > maxBytes {
+		return nil, &BodyTooLargeError{Limit: maxBytes}
+	}
+
+	return body, nil
+}
 
-	dec := json.NewDecoder(r.Body)
+// decodeJSON decodes body, which must hold exactly one JSON value, into data, applying the
+// StrictJSON, structural limit, AllowUnknownFields and ValidateJSON settings and mapping failures
+// to the typed errors.
+func (t *Tools) decodeJSON(body []byte, data any) error {
+	if t.needsJSONScan() {
+		if err := t.scanJSON(body, reflect.TypeOf(data)); err != nil {
+			return err
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
 
 	if !t.AllowUnknownFields {
 		dec.DisallowUnknownFields()
@@ -245,23 +347,24 @@ func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data any) error
 
 		switch {
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+			return &BadJSONError{Position: jsonPosition(body, syntaxError.Offset)}
 		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
+			return &BadJSONError{Position: jsonPosition(body, int64(len(body)))}
 		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+			return &JSONTypeError{
+				Position: jsonPosition(body, unmarshalTypeError.Offset),
+				Field:    unmarshalTypeError.Field,
 			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
 		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+			return ErrEmptyBody
 		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+			field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			if unquoted, err := strconv.Unquote(field); err == nil {
+				field = unquoted
+			}
+			return &UnknownFieldError{Field: field}
 		case errors.As(err, &invalidUnmarshalError):
-			return fmt.Errorf("error unmarshalling JSON: %s", err.Error())
+			return fmt.Errorf("error unmarshalling JSON: %w", err)
 		default:
 			return err
 		}
@@ -269,26 +372,141 @@ func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data any) error
 
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must have only a single JSON value")
+		return ErrMultipleJSONValues
+	}
+
+	if t.ValidateJSON {
+		return t.Validate(data)
 	}
 
 	return nil
 }
 
+// ReadJSONAs is the generic counterpart of ReadJSON. It decodes the body of a request into
+// a new value of type T and returns it, using exactly the same limits and error messages as ReadJSON.
+func ReadJSONAs[T any](t *Tools, w http.ResponseWriter, r *http.Request) (T, error) {
+	var data T
+	if err := t.ReadJSON(w, r, &data); err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return data, nil
+}
+
 // WriteJSON takes a response status code and arbitrary data and writes JSON to the client.
+// When w comes from WithRequest and Compress is set, the body is compressed according to
+// the request's Accept-Encoding header. A 200 response to a GET or HEAD request through
+// WithRequest is replaced with 304 Not Modified when the request's If-None-Match matches
+// its ETag (computed from the data before Envelope wraps it when ETags is set, or passed in
+// headers as an explicit version), or when its If-Modified-Since is not before a
+// Last-Modified passed in headers.
+//
+// Successful responses are pruned to the AllowedFields allow-list and, when SparseFields is set
+// and w comes from WithRequest, to the fields named in the request's fields query parameter.
+// For a Page, the fields apply to each item of its data. A malformed fields parameter results
+// in a *QueryParamError, and nothing is written. The data is then wrapped by Envelope, if set.
 func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data any, headers ...http.Header) error {
+	data, err := t.responseData(w, status, data)
+	if err != nil {
+		return err
+	}
+	if err := t.setETag(w, status, data, json.Marshal); err != nil {
+		return err
+	}
+
+	data = t.envelope().Success(status, data, envelopeMeta(w))
+
+	return t.writeJSON(w, status, data, "application/json", headers...)
+}
+
+// responseData sets the Link header for a Page and prunes a successful response to the fields
+// allowed for w, returning the pruned data as a json.RawMessage.
+func (t *Tools) responseData(w http.ResponseWriter, status int, data any) (any, error) {
+	page, isPage := data.(linkedPage)
+
+	var fields FieldSet
+	if status < http.StatusMultipleChoices {
+		var err error
+		if fields, err = t.responseFields(w); err != nil {
+			return nil, err
+		}
+	}
+
+	if isPage {
+		t.SetLinkHeader(w, page.pageLinks())
+	}
+
+	if fields == nil {
+		return data, nil
+	}
+
+	if isPage {
+		fields = FieldSet{"data": fields, "meta": nil, "links": nil}
+	}
+
 	out, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if out, err = fields.Filter(out); err != nil {
+		return nil, err
+	}
+
+	return json.RawMessage(out), nil
+}
+
+// setETag sets the ETag of a 200 response to w from data encoded with marshal, before Envelope
+// wraps it, so that volatile envelope metadata such as the request ID does not change the tag.
+// Without an Envelope, or when the response already has an ETag, it leaves the tag to writeBody.
+func (t *Tools) setETag(w http.ResponseWriter, status int, data any, marshal func(any) ([]byte, error)) error {
+	if !t.ETags || t.Envelope == nil || status != http.StatusOK || requestOf(w) == nil || w.Header().Get("ETag") != "" {
+		return nil
+	}
+
+	out, err := marshal(data)
 	if err != nil {
 		return err
 	}
+	w.Header().Set("ETag", etagFor(out))
 
+	return nil
+}
+
+// writeJSON marshals data and writes it to the client with the given status code and content type.
+func (t *Tools) writeJSON(w http.ResponseWriter, status int, data any, contentType string, headers ...http.Header) error {
+	out, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return t.writeBody(w, status, out, contentType, headers...)
+}
+
+// writeBody writes an already encoded response body with the given status code and content type.
+func (t *Tools) writeBody(w http.ResponseWriter, status int, out []byte, contentType string, headers ...http.Header) error {
 	if len(headers) > 0 {
 		for key, value := range headers[0] {
 			w.Header()[key] = value
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if r := requestOf(w); r != nil && status == http.StatusOK {
+		if t.ETags && w.Header().Get("ETag") == "" {
+			w.Header().Set("ETag", etagFor(out))
+		}
+		if t.notModified(r, w.Header()) {
+			t.writeNotModified(w)
+			return nil
+		}
+	}
+
+	out, err := t.compressBody(w, out)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
 	_, err = w.Write(out)
 	if err != nil {
@@ -299,50 +517,72 @@ func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data any, headers .
 }
 
 // ErrorJSON takes an error, and optionally a response status code, and generates and sends
+// a JSON error response. If no status code is given, the one reported by an HTTPStatusError
+// in err's chain is used, falling back to 400 Bad Request. When UseProblemDetails is set,
+// the response is an RFC 9457 problem details object, whose status is always that of the
+// response, even if err wraps a *Problem with another one; otherwise it is shaped by Envelope,
+// which by default writes a JSONResponse.
 func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error {
-	statusCode := http.StatusBadRequest
+	statusCode := statusFromError(err, http.StatusBadRequest)
 
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
 
-	payload := JSONResponse{
-		Error:   true,
-		Message: err.Error(),
+	if t.UseProblemDetails {
+		return t.ProblemJSON(w, problemFromError(err, statusCode))
 	}
 
-	return t.WriteJSON(w, statusCode, payload)
+	payload := t.envelope().Error(statusCode, err, envelopeMeta(w))
+
+	return t.writeJSON(w, statusCode, payload, "application/json")
 }
 
 // PushJSONToRemote posts arbitrary data to some URL as JSON. It returns the response, status code and error, if any.
-// the final parameter, client, is optional. If not provided, the default http client is used.
+// the final parameter, client, is optional. If not provided, HTTPClient or a default client is used.
 func (t *Tools) PushJSONToRemote(uri string, data any, client ...*http.Client) (*http.Response, int, error) {
-	// create json
-	jsonData, err := json.Marshal(data)
+	response, statusCode, err := t.pushJSON(uri, data, client...)
 	if err != nil {
 		return nil, 0, err
 	}
+	defer response.Body.Close()
 
-	// check for custom http client
-	httpClient := &http.Client{}
+	// send response back
+	return response, statusCode, nil
+}
+
+// pushJSON posts data as JSON to uri and returns the response with its body still open.
+func (t *Tools) pushJSON(uri string, data any, client ...*http.Client) (*http.Response, int, error) {
+	o := &requestOptions{header: make(http.Header), newIdempotencyKey: t.IdempotencyKeys}
 	if len(client) > 0 {
-		httpClient = client[0]
+		o.client = client[0]
 	}
 
-	// build the request and set header
-	request, err := http.NewRequest("POST", uri, bytes.NewBuffer(jsonData))
+	response, err := t.sendJSON(context.Background(), http.MethodPost, uri, data, o)
 	if err != nil {
 		return nil, 0, err
 	}
-	request.Header.Set("Content-Type", "application/json")
 
-	// call the remote uri
-	response, err := httpClient.Do(request)
+	return response, response.StatusCode, nil
+}
+
+// PushJSONToRemoteAs posts arbitrary data to some URL as JSON, like PushJSONToRemote, and decodes
+// the JSON response body into a new value of type T. An empty response body leaves T at its zero value.
+// The final parameter, client, is optional. If not provided, HTTPClient or a default client is used.
+func PushJSONToRemoteAs[T any](t *Tools, uri string, data any, client ...*http.Client) (T, *http.Response, int, error) {
+	var result T
+
+	response, statusCode, err := t.pushJSON(uri, data, client...)
 	if err != nil {
-		return nil, 0, err
+		return result, nil, 0, err
 	}
 	defer response.Body.Close()
 
-	// send response back
-	return response, response.StatusCode, nil
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil && !errors.Is(err, io.EOF) {
+		var zero T
+		return zero, response, statusCode, fmt.Errorf("error decoding response body: %w", err)
+	}
+
+	return result, response, statusCode, nil
 }
@@ -56,6 +56,17 @@ func TestTools_RandomString(t *testing.T) {
 	assert.Equal(t, 10, len(s))
 }
 
+func TestRandomID(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, n := range []int{1, 20, 32, 33} {
+		id := randomID(n)
+		assert.Len(t, id, n)
+		assert.Regexp(t, `^[A-Za-z0-9_-]+$`, id)
+		assert.False(t, seen[id])
+		seen[id] = true
+	}
+}
+
 const (
 	jpegType    = "image/jpeg"
 	pngType     = "image/png"
@@ -430,3 +441,45 @@ func TestTools_ErrorJSON(t *testing.T) {
 	assert.True(t, payload.Error)
 	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
 }
+
+func TestTools_ReadJSONAs(t *testing.T) {
+	var testTools Tools
+
+	type foo struct {
+		Foo string `json:"foo"`
+	}
+
+	req, err := http.NewRequest("POST", "/", bytes.NewReader([]byte(`{"foo": "bar"}`)))
+	assert.NoError(t, err)
+
+	decoded, err := ReadJSONAs[foo](&testTools, httptest.NewRecorder(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", decoded.Foo)
+
+	req, err = http.NewRequest("POST", "/", bytes.NewReader([]byte(`{"foo": 1}`)))
+	assert.NoError(t, err)
+
+	decoded, err = ReadJSONAs[foo](&testTools, httptest.NewRecorder(), req)
+	assert.Error(t, err)
+	assert.Equal(t, foo{}, decoded)
+}
+
+func TestTools_PushJSONToRemoteAs(t *testing.T) {
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusCreated,
+			Body:       io.NopCloser(bytes.NewBufferString(`{"success": true}`)),
+			Header:     make(http.Header),
+		}
+	})
+
+	type reply struct {
+		Success bool `json:"success"`
+	}
+
+	var testTool Tools
+	decoded, _, status, err := PushJSONToRemoteAs[reply](&testTool, "http://example.com/some/path", map[string]string{"bar": "baz"}, client)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, status)
+	assert.True(t, decoded.Success)
+}
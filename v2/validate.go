@@ -0,0 +1,261 @@
+package toolkit
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError describes a single failed validation rule. Field is the JSON name of the field,
+// using dots for nested objects and brackets for array elements, e.g. "items[2].name".
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is returned by Validate, and by ReadJSON when ValidateJSON is set,
+// and lists every field that failed validation.
+type ValidationErrors []FieldError
+
+// Error implements the error interface.
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, fe := range v {
+		msgs = append(msgs, fe.Message)
+	}
+
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks data, which must be a struct or a pointer to a struct, against the rules
+// in its validate struct tags. Rules are separated by commas, e.g. `validate:"required,min=3,email"`.
+// The supported rules are:
+//
+//	required     the field must not be its zero value (or nil, for pointers, slices and maps)
+//	min=n        minimum length for strings, slices and maps, minimum value for numbers
+//	max=n        maximum length for strings, slices and maps, maximum value for numbers
+//	len=n        exact length for strings, slices and maps
+//	email        the string must be a valid email address
+//	oneof=a b c  the value must be one of the space separated options
+//
+// Nested structs, pointers to structs and slices of structs are validated recursively.
+// Only the first failing rule of each field is reported. If any rule fails, the returned error
+// is a ValidationErrors value.
+func (t *Tools) Validate(data any) error {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs ValidationErrors
+	validateStruct(v, "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// validateStruct walks the fields of v and appends a FieldError to errs for every failed rule.
+func validateStruct(v reflect.Value, prefix string, errs *ValidationErrors) {
+	typ := v.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		fv := v.Field(i)
+		name, skip := jsonFieldName(sf)
+		if skip {
+			continue
+		}
+
+		// embedded structs without a json name are flattened, as encoding/json does
+		if sf.Anonymous && sf.Tag.Get("json") == "" {
+			ev := fv
+			if ev.Kind() == reflect.Pointer {
+				if ev.IsNil() {
+					continue
+				}
+				ev = ev.Elem()
+			}
+			if ev.Kind() == reflect.Struct {
+				validateStruct(ev, prefix, errs)
+				continue
+			}
+		}
+
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		if tag := sf.Tag.Get("validate"); tag != "" && tag != "-" {
+			validateField(fv, path, tag, errs)
+		}
+
+		validateNested(fv, path, errs)
+	}
+}
+
+// validateNested descends into struct, pointer and slice values so that their own tags are checked.
+func validateNested(v reflect.Value, path string, errs *ValidationErrors) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			validateNested(v.Elem(), path, errs)
+		}
+	case reflect.Struct:
+		validateStruct(v, path, errs)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateNested(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+// validateField applies the rules in tag to v, in order, and records the first one that fails.
+func validateField(v reflect.Value, path, tag string, errs *ValidationErrors) {
+	rules := strings.Split(tag, ",")
+
+	// a nil pointer only fails "required"; the remaining rules apply to the pointed-to value
+	isPointer := v.Kind() == reflect.Pointer
+	if isPointer {
+		if v.IsNil() {
+			for _, rule := range rules {
+				if strings.TrimSpace(rule) == "required" {
+					*errs = append(*errs, FieldError{Field: path, Rule: "required", Message: fmt.Sprintf("%s is required", path)})
+				}
+			}
+			return
+		}
+		v = v.Elem()
+	}
+
+	for _, rule := range rules {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if name == "" || (isPointer && name == "required") {
+			continue
+		}
+
+		if msg, ok := checkRule(v, name, param); !ok {
+			*errs = append(*errs, FieldError{Field: path, Rule: name, Param: param, Message: fmt.Sprintf("%s %s", path, msg)})
+			return
+		}
+	}
+}
+
+// checkRule reports whether v satisfies a single rule and, if it does not, a message explaining why.
+func checkRule(v reflect.Value, rule, param string) (string, bool) {
+	switch rule {
+	case "required":
+		if v.IsZero() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0) {
+			return "is required", false
+		}
+	case "min", "max", "len":
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return fmt.Sprintf("has an invalid %s rule %q", rule, param), false
+		}
+		return checkBound(v, rule, n)
+	case "email":
+		if v.Kind() != reflect.String {
+			return "must be a string", false
+		}
+		if s := v.String(); s != "" {
+			addr, err := mail.ParseAddress(s)
+			if err != nil || addr.Address != s {
+				return "must be a valid email address", false
+			}
+		}
+	case "oneof":
+		options := strings.Fields(param)
+		s := fmt.Sprint(v.Interface())
+		for _, o := range options {
+			if s == o {
+				return "", true
+			}
+		}
+		return fmt.Sprintf("must be one of [%s]", strings.Join(options, ", ")), false
+	default:
+		return fmt.Sprintf("has an unknown validation rule %q", rule), false
+	}
+
+	return "", true
+}
+
+// checkBound applies a min, max or len rule, measuring length for strings, slices and maps and value for numbers.
+func checkBound(v reflect.Value, rule string, n float64) (string, bool) {
+	var size float64
+	unit := ""
+
+	switch v.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		size, unit = float64(v.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = v.Float()
+	default:
+		return fmt.Sprintf("does not support the %s rule", rule), false
+	}
+
+	limit := strconv.FormatFloat(n, 'f', -1, 64)
+	switch rule {
+	case "min":
+		if size < n {
+			if unit != "" {
+				return fmt.Sprintf("must have at least %s%s", limit, unit), false
+			}
+			return fmt.Sprintf("must be at least %s", limit), false
+		}
+	case "max":
+		if size > n {
+			if unit != "" {
+				return fmt.Sprintf("must have at most %s%s", limit, unit), false
+			}
+			return fmt.Sprintf("must be at most %s", limit), false
+		}
+	case "len":
+		if unit == "" {
+			return "does not support the len rule", false
+		}
+		if size != n {
+			return fmt.Sprintf("must have exactly %s%s", limit, unit), false
+		}
+	}
+
+	return "", true
+}
+
+// jsonFieldName returns the name encoding/json uses for sf, and whether the field is skipped entirely.
+func jsonFieldName(sf reflect.StructField) (string, bool) {
+	tag := sf.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = sf.Name
+	}
+
+	return name, false
+}
@@ -0,0 +1,81 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validateAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type validateUser struct {
+	Name     string            `json:"name" validate:"required,min=3,max=10"`
+	Email    string            `json:"email" validate:"required,email"`
+	Role     string            `json:"role" validate:"oneof=admin user"`
+	Age      int               `json:"age" validate:"min=18"`
+	Nickname *string           `json:"nickname,omitempty" validate:"min=2"`
+	Tags     []string          `json:"tags" validate:"max=2"`
+	Address  *validateAddress  `json:"address" validate:"required"`
+	Others   []validateAddress `json:"others"`
+}
+
+var validateTests = []struct {
+	name           string
+	json           string
+	expectedFields []string
+}{
+	{
+		name:           "valid",
+		json:           `{"name":"jack","email":"jack@example.com","role":"admin","age":30,"address":{"city":"Recife"}}`,
+		expectedFields: nil,
+	},
+	{
+		name:           "missing required",
+		json:           `{"role":"user","age":30}`,
+		expectedFields: []string{"name", "email", "address"},
+	},
+	{
+		name:           "bad values",
+		json:           `{"name":"jo","email":"not-an-email","role":"root","age":12,"nickname":"j","tags":["a","b","c"],"address":{"city":"Recife"}}`,
+		expectedFields: []string{"name", "email", "role", "age", "nickname", "tags"},
+	},
+	{
+		name:           "nested",
+		json:           `{"name":"jack","email":"jack@example.com","role":"user","age":30,"address":{"city":""},"others":[{"city":"Natal"},{}]}`,
+		expectedFields: []string{"address.city", "others[1].city"},
+	},
+}
+
+func TestTools_Validate(t *testing.T) {
+	testTools := Tools{ValidateJSON: true}
+
+	for _, e := range validateTests {
+		t.Run(e.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/", bytes.NewReader([]byte(e.json)))
+			assert.NoError(t, err)
+
+			var user validateUser
+			err = testTools.ReadJSON(httptest.NewRecorder(), req, &user)
+
+			if len(e.expectedFields) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			var validationErrors ValidationErrors
+			assert.True(t, errors.As(err, &validationErrors))
+
+			var fields []string
+			for _, fe := range validationErrors {
+				fields = append(fields, fe.Field)
+			}
+			assert.Equal(t, e.expectedFields, fields)
+		})
+	}
+}
@@ -0,0 +1,751 @@
+package toolkit
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of a signed webhook, as defined by the Standard Webhooks specification.
+const (
+	webhookIDHeader        = "Webhook-Id"
+	webhookTimestampHeader = "Webhook-Timestamp"
+	webhookSignatureHeader = "Webhook-Signature"
+)
+
+// Defaults used by WebhookOptions when its fields are not set.
+const (
+	defaultWebhookAttempts  = 8
+	defaultWebhookBaseDelay = 30 * time.Second
+	defaultWebhookMaxDelay  = 6 * time.Hour
+	defaultWebhookTimeout   = 30 * time.Second
+	defaultWebhookPoll      = time.Second
+	defaultWebhookWorkers   = 4
+	defaultWebhookLogSize   = 10 << 20
+)
+
+// Outcomes of a WebhookDelivery.
+const (
+	WebhookDelivered = "delivered" // the endpoint answered with a 2xx status
+	WebhookRetrying  = "retrying"  // the attempt failed and the message will be sent again
+	WebhookDead      = "dead"      // the attempt failed and the message was moved to the dead-letter list
+)
+
+// SignWebhook returns the Standard Webhooks signature of a message, in the form "v1,<base64>":
+// the HMAC-SHA256, keyed with secret, of the message ID, the timestamp in Unix seconds and the
+// body, joined with dots.
+func SignWebhook(secret []byte, id string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id + "." + strconv.FormatInt(timestamp.Unix(), 10) + "."))
+	mac.Write(body)
+
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// webhookSecret decodes a signing secret. Secrets in the Standard Webhooks form "whsec_<base64>"
+// are decoded; any other string is used as it is.
+func webhookSecret(secret string) ([]byte, error) {
+	if encoded, ok := strings.CutPrefix(secret, "whsec_"); ok {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook secret: %w", err)
+		}
+		return key, nil
+	}
+	if secret == "" {
+		return nil, errors.New("webhook secret must not be empty")
+	}
+
+	return []byte(secret), nil
+}
+
+// WebhookMessage is a webhook waiting in the queue or on the dead-letter list.
+type WebhookMessage struct {
+	ID          string          `json:"id"`
+	URL         string          `json:"url"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	CreatedAt   time.Time       `json:"created_at"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// WebhookDelivery is an entry of the delivery log, recording one attempt to send a message.
+// Outcome is WebhookDelivered, WebhookRetrying or WebhookDead. StatusCode is 0 if no response
+// was received, in which case Error says why.
+type WebhookDelivery struct {
+	MessageID  string        `json:"message_id"`
+	URL        string        `json:"url"`
+	Attempt    int           `json:"attempt"`
+	Time       time.Time     `json:"time"`
+	Duration   time.Duration `json:"duration"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Outcome    string        `json:"outcome"`
+}
+
+// WebhookDeliveryQuery selects entries of the delivery log. Zero fields match every entry.
+type WebhookDeliveryQuery struct {
+	MessageID string    // only attempts for this message
+	URL       string    // only attempts sent to this URL
+	Failed    bool      // only failed attempts
+	Since     time.Time // only attempts made at or after this time
+	Limit     int       // at most this many entries, the most recent ones
+}
+
+// WebhookOptions configures a WebhookDispatcher.
+type WebhookOptions struct {
+	// Retry sets how many times a message is sent before it is moved to the dead-letter list, and
+	// how long to wait between attempts. Its hooks and RetryIf are not used; any status other
+	// than 2xx is a failure. nil means 8 attempts, with delays from 30s up to 6h.
+	Retry *RetryPolicy
+
+	Timeout      time.Duration // limit on each attempt; 0 means 30s
+	PollInterval time.Duration // how often Run looks for messages that are due; 0 means 1s
+	Concurrency  int           // how many endpoints are sent to at once; 0 means 4
+	MaxLogSize   int64         // size in bytes at which the delivery log is rotated; 0 means 10 MiB
+
+	// ErrorLog receives problems with the queue that do not stop delivery, such as a message file
+	// that cannot be read. nil means they are not logged.
+	ErrorLog *log.Logger
+}
+
+// WebhookDispatcher sends signed webhooks from a durable queue kept on disk. Each message is
+// signed as the Standard Webhooks specification describes, with the Webhook-Id,
+// Webhook-Timestamp and Webhook-Signature headers, and sent with POST through the Tools HTTP
+// client. Failed attempts are retried with exponential backoff; when the attempts run out, or
+// the endpoint answers 410 Gone, the message is moved to the dead-letter list. Every attempt is
+// appended to a delivery log, which is rotated when it reaches WebhookOptions.MaxLogSize: the
+// previous log is kept, and the one before it deleted, so the log never holds more than twice
+// that size. While the Tools CircuitBreaker is open for an endpoint, its messages are put back
+// in the queue without using up an attempt.
+//
+// Messages for the same URL are sent one at a time, oldest first, and up to
+// WebhookOptions.Concurrency URLs are sent to at once, so a slow endpoint does not hold up the
+// others. The dispatcher keeps the due time of every queued message in memory, reading the queue
+// directory only when it is created, so a directory should be used by one dispatcher at a time.
+//
+// Delivery is at least once: a message whose attempt was cut short by a crash is sent again, with
+// the same Webhook-Id, which receivers can use to discard duplicates. A message file that cannot
+// be decoded is moved to the corrupt directory next to the queue and reported to
+// WebhookOptions.ErrorLog, and one that cannot be read is skipped, so neither stops the others.
+type WebhookDispatcher struct {
+	tools   *Tools
+	dir     string
+	secret  []byte
+	retry   *RetryPolicy
+	timeout time.Duration
+	poll    time.Duration
+	logSize int64
+	workers int
+	errLog  *log.Logger
+
+	mu        sync.Mutex            // guards the files and due
+	due       map[string]webhookDue // the queued messages, by ID
+	deliverMu sync.Mutex            // allows one DeliverDue at a time
+}
+
+// webhookDue is what a WebhookDispatcher remembers of a queued message, to find the ones that
+// are due without reading the queue.
+type webhookDue struct {
+	url         string
+	nextAttempt time.Time
+	createdAt   time.Time
+}
+
+// NewWebhookDispatcher returns a dispatcher keeping its queue, dead-letter list and delivery log
+// in dir, which is created if it does not exist, and signing messages with secret. A secret in
+// the Standard Webhooks form "whsec_<base64>" is decoded first. The final parameter, opts, is
+// optional.
+func (t *Tools) NewWebhookDispatcher(dir, secret string, opts ...WebhookOptions) (*WebhookDispatcher, error) {
+	var opt WebhookOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
+	key, err := webhookSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &WebhookDispatcher{
+		tools:   t,
+		dir:     dir,
+		secret:  key,
+		retry:   opt.Retry,
+		timeout: opt.Timeout,
+		poll:    opt.PollInterval,
+		logSize: opt.MaxLogSize,
+		workers: opt.Concurrency,
+		errLog:  opt.ErrorLog,
+		due:     make(map[string]webhookDue),
+	}
+	if d.retry == nil {
+		d.retry = &RetryPolicy{MaxAttempts: defaultWebhookAttempts, BaseDelay: defaultWebhookBaseDelay, MaxDelay: defaultWebhookMaxDelay}
+	}
+	if d.timeout <= 0 {
+		d.timeout = defaultWebhookTimeout
+	}
+	if d.poll <= 0 {
+		d.poll = defaultWebhookPoll
+	}
+	if d.logSize <= 0 {
+		d.logSize = defaultWebhookLogSize
+	}
+	if d.workers <= 0 {
+		d.workers = defaultWebhookWorkers
+	}
+
+	for _, sub := range []string{"queue", "dead"} {
+		if err := t.CreateDirIfNotExist(filepath.Join(dir, sub)); err != nil {
+			return nil, err
+		}
+
+		// temporary files left behind by a crash in the middle of save
+		temps, err := filepath.Glob(filepath.Join(dir, sub, ".tmp-*"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range temps {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+
+	queued, err := d.list("queue")
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range queued {
+		d.due[msg.ID] = webhookDue{url: msg.URL, nextAttempt: msg.NextAttempt, createdAt: msg.CreatedAt}
+	}
+
+	return d, nil
+}
+
+// Enqueue adds a message to the queue, to be sent to url with payload encoded as JSON as its
+// body. It returns the message ID, which is sent in the Webhook-Id header.
+func (d *WebhookDispatcher) Enqueue(url string, payload any) (string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	now := d.tools.clock().Now()
+	msg := &WebhookMessage{
+		ID:          "msg_" + d.tools.RandomString(24),
+		URL:         url,
+		Payload:     body,
+		NextAttempt: now,
+		CreatedAt:   now,
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.save("queue", msg); err != nil {
+		return "", err
+	}
+
+	return msg.ID, nil
+}
+
+// Run delivers messages as they become due, until ctx ends, when it returns nil. It returns an
+// error if the queue cannot be read or updated.
+func (d *WebhookDispatcher) Run(ctx context.Context) error {
+	clock := d.tools.clock()
+	for {
+		if _, err := d.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+			return err
+		}
+		if clock.Sleep(ctx, d.poll) != nil {
+			return nil
+		}
+	}
+}
+
+// DeliverDue makes one attempt to send each queued message that is due, and returns how many
+// were delivered. Messages for the same URL are sent oldest first, one at a time. Run calls it
+// periodically; it can also be called directly, for example from a scheduled job.
+func (d *WebhookDispatcher) DeliverDue(ctx context.Context) (int, error) {
+	d.deliverMu.Lock()
+	defer d.deliverMu.Unlock()
+
+	d.mu.Lock()
+	groups := d.dueGroups(d.tools.clock().Now())
+	d.mu.Unlock()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		delivered int
+		errs      []error
+	)
+	workers := make(chan struct{}, d.workers)
+	for _, ids := range groups {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			n, err := d.deliverAll(ctx, ids)
+			mu.Lock()
+			defer mu.Unlock()
+			delivered += n
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return delivered, errors.Join(errs...)
+}
+
+// dueGroups returns the IDs of the queued messages that are due at now, grouped by URL and
+// ordered by their next attempt and then by when they were created. The caller must hold mu.
+func (d *WebhookDispatcher) dueGroups(now time.Time) [][]string {
+	var ids []string
+	for id, due := range d.due {
+		if !due.nextAttempt.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		if c := d.due[a].nextAttempt.Compare(d.due[b].nextAttempt); c != 0 {
+			return c
+		}
+		if c := d.due[a].createdAt.Compare(d.due[b].createdAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+
+	var groups [][]string
+	index := make(map[string]int)
+	for _, id := range ids {
+		url := d.due[id].url
+		i, ok := index[url]
+		if !ok {
+			i = len(groups)
+			index[url] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], id)
+	}
+
+	return groups
+}
+
+// deliverAll makes one attempt to send each of the queued messages with the given IDs, in order,
+// and returns how many were delivered. It stops at the first error.
+func (d *WebhookDispatcher) deliverAll(ctx context.Context, ids []string) (int, error) {
+	delivered := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+
+		d.mu.Lock()
+		msg := d.loadQueued(id)
+		d.mu.Unlock()
+		if msg == nil {
+			continue
+		}
+
+		ok, err := d.deliver(ctx, msg)
+		if err != nil {
+			return delivered, err
+		}
+		if ok {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
+// loadQueued reads the queued message with the given ID. It returns nil if the message is gone or
+// corrupt, after forgetting it, or cannot be read this time, after logging why. The caller must
+// hold mu.
+func (d *WebhookDispatcher) loadQueued(id string) *WebhookMessage {
+	path := filepath.Join(d.dir, "queue", id+".json")
+	msg, err := d.load(path)
+	var corrupt *corruptMessageError
+	switch {
+	case err == nil:
+		return msg
+	case errors.As(err, &corrupt):
+		d.quarantine(path, err)
+		delete(d.due, id)
+	case errors.Is(err, os.ErrNotExist):
+		delete(d.due, id)
+	default:
+		d.logf("webhook: skipping %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// deliver makes one attempt to send msg and records the result, reporting whether it was delivered.
+func (d *WebhookDispatcher) deliver(ctx context.Context, msg *WebhookMessage) (bool, error) {
+	clock := d.tools.clock()
+	start := clock.Now()
+
+	attemptCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	header := make(http.Header)
+	header.Set(webhookIDHeader, msg.ID)
+	header.Set(webhookTimestampHeader, strconv.FormatInt(start.Unix(), 10))
+	header.Set(webhookSignatureHeader, SignWebhook(d.secret, msg.ID, start, msg.Payload))
+
+	resp, err := d.tools.sendJSON(attemptCtx, http.MethodPost, msg.URL, msg.Payload, &requestOptions{header: header})
+	if err != nil && ctx.Err() != nil {
+		// the dispatcher is stopping, so the attempt does not count
+		return false, nil
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		// nothing was sent, so the message waits for the circuit to close without using an attempt
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		wait := defaultBreakerCoolDown
+		if d.tools.CircuitBreaker != nil {
+			wait = d.tools.CircuitBreaker.coolDown()
+		}
+		msg.LastError = err.Error()
+		msg.NextAttempt = clock.Now().Add(wait)
+		return false, d.save("queue", msg)
+	}
+	if resp != nil {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+	}
+
+	msg.Attempts++
+	entry := WebhookDelivery{
+		MessageID: msg.ID,
+		URL:       msg.URL,
+		Attempt:   msg.Attempts,
+		Time:      start,
+		Duration:  clock.Now().Sub(start),
+	}
+	switch {
+	case err != nil:
+		entry.Error = err.Error()
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		entry.StatusCode = resp.StatusCode
+		entry.Error = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	default:
+		entry.StatusCode = resp.StatusCode
+	}
+
+	var delay time.Duration
+	switch {
+	case entry.Error == "":
+		entry.Outcome = WebhookDelivered
+	case msg.Attempts >= d.retry.maxAttempts() || entry.StatusCode == http.StatusGone:
+		entry.Outcome = WebhookDead
+	default:
+		entry.Outcome = WebhookRetrying
+		var ok bool
+		if delay, ok = d.retry.delay(clock, msg.Attempts, resp); !ok {
+			delay = d.retry.maxDelay()
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.appendLog(entry); err != nil {
+		return false, err
+	}
+
+	msg.LastError = entry.Error
+	switch entry.Outcome {
+	case WebhookDelivered:
+		return true, d.remove("queue", msg.ID)
+	case WebhookDead:
+		if err := d.save("dead", msg); err != nil {
+			return false, err
+		}
+		return false, d.remove("queue", msg.ID)
+	default:
+		msg.NextAttempt = clock.Now().Add(delay)
+		return false, d.save("queue", msg)
+	}
+}
+
+// Queued returns the messages waiting to be sent, oldest first.
+func (d *WebhookDispatcher) Queued() ([]*WebhookMessage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.list("queue")
+}
+
+// DeadLetters returns the messages that could not be delivered, oldest first.
+func (d *WebhookDispatcher) DeadLetters() ([]*WebhookMessage, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.list("dead")
+}
+
+// Redeliver moves the message with the given ID from the dead-letter list back to the queue, to
+// be sent again with a fresh set of attempts.
+func (d *WebhookDispatcher) Redeliver(id string) error {
+	if id == "" || filepath.Base(id) != id {
+		return fmt.Errorf("invalid webhook message ID %q", id)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	msg, err := d.load(filepath.Join(d.dir, "dead", id+".json"))
+	if err != nil {
+		return err
+	}
+	msg.Attempts = 0
+	msg.NextAttempt = d.tools.clock().Now()
+	if err := d.save("queue", msg); err != nil {
+		return err
+	}
+
+	return d.remove("dead", id)
+}
+
+// Deliveries returns the entries of the delivery log that match q, oldest first. Entries older than
+// the previous rotation of the log are no longer available.
+func (d *WebhookDispatcher) Deliveries(q WebhookDeliveryQuery) ([]WebhookDelivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var entries []WebhookDelivery
+	for _, name := range []string{"deliveries.jsonl.1", "deliveries.jsonl"} {
+		var err error
+		if entries, err = readWebhookLog(filepath.Join(d.dir, name), q, entries); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[len(entries)-q.Limit:]
+	}
+
+	return entries, nil
+}
+
+// readWebhookLog appends the entries of the log file path that match q to entries. A missing
+// file has no entries.
+func readWebhookLog(path string, q WebhookDeliveryQuery, entries []WebhookDelivery) ([]WebhookDelivery, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry WebhookDelivery
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a line cut short by a crash is skipped
+			continue
+		}
+		switch {
+		case q.MessageID != "" && entry.MessageID != q.MessageID,
+			q.URL != "" && entry.URL != q.URL,
+			q.Failed && entry.Outcome == WebhookDelivered,
+			entry.Time.Before(q.Since):
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// appendLog adds entry to the delivery log, first rotating the log if the entry would take it
+// past logSize. The caller must hold mu.
+func (d *WebhookDispatcher) appendLog(entry WebhookDelivery) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(d.dir, "deliveries.jsonl")
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 && info.Size()+int64(len(line))+1 > d.logSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// save writes msg to the given list, replacing it atomically. The caller must hold mu.
+func (d *WebhookDispatcher) save(list string, msg *WebhookMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(d.dir, list)
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(dir, msg.ID+".json")); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if list == "queue" {
+		d.due[msg.ID] = webhookDue{url: msg.URL, nextAttempt: msg.NextAttempt, createdAt: msg.CreatedAt}
+	}
+
+	return nil
+}
+
+// remove deletes a message from the given list. The caller must hold mu.
+func (d *WebhookDispatcher) remove(list, id string) error {
+	if err := os.Remove(filepath.Join(d.dir, list, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if list == "queue" {
+		delete(d.due, id)
+	}
+
+	return nil
+}
+
+// list returns the messages in the given list, ordered by their next attempt and then by when
+// they were created. The caller must hold mu.
+func (d *WebhookDispatcher) list(list string) ([]*WebhookMessage, error) {
+	paths, err := filepath.Glob(filepath.Join(d.dir, list, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*WebhookMessage, 0, len(paths))
+	for _, path := range paths {
+		msg, err := d.load(path)
+		var corrupt *corruptMessageError
+		switch {
+		case errors.As(err, &corrupt):
+			d.quarantine(path, err)
+			continue
+		case errors.Is(err, os.ErrNotExist):
+			continue
+		case err != nil:
+			d.logf("webhook: skipping %s: %v", path, err)
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+
+	slices.SortStableFunc(msgs, func(a, b *WebhookMessage) int {
+		if c := a.NextAttempt.Compare(b.NextAttempt); c != 0 {
+			return c
+		}
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return msgs, nil
+}
+
+// load reads the message in path.
+func (d *WebhookDispatcher) load(path string) (*WebhookMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg WebhookMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, &corruptMessageError{name: filepath.Base(path), err: err}
+	}
+
+	return &msg, nil
+}
+
+// corruptMessageError is returned by load for a message file that is not a valid message.
+type corruptMessageError struct {
+	name string
+	err  error
+}
+
+func (e *corruptMessageError) Error() string {
+	return fmt.Sprintf("error reading webhook message %s: %v", e.name, e.err)
+}
+
+func (e *corruptMessageError) Unwrap() error { return e.err }
+
+// quarantine moves the corrupt message file in path to the corrupt directory, where it no longer
+// holds up the list it was in, and logs why. The caller must hold mu.
+func (d *WebhookDispatcher) quarantine(path string, reason error) {
+	dir := filepath.Join(d.dir, "corrupt")
+	err := d.tools.CreateDirIfNotExist(dir)
+	if err == nil {
+		err = os.Rename(path, filepath.Join(dir, filepath.Base(filepath.Dir(path))+"-"+filepath.Base(path)))
+	}
+	if err != nil {
+		d.logf("webhook: skipping %s: %v; it could not be moved aside: %v", path, reason, err)
+		return
+	}
+
+	d.logf("webhook: moved %s to %s: %v", path, dir, reason)
+}
+
+// logf logs a problem with the queue to ErrorLog.
+func (d *WebhookDispatcher) logf(format string, args ...any) {
+	if d.errLog != nil {
+		d.errLog.Printf(format, args...)
+	}
+}
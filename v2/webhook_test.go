@@ -0,0 +1,400 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignWebhook(t *testing.T) {
+	// test vector from the Standard Webhooks specification
+	secret, err := webhookSecret("whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw")
+	assert.NoError(t, err)
+
+	sig := SignWebhook(secret, "msg_p5jXN8AQM9LWM0D4loKWxJek", time.Unix(1614265330, 0), []byte(`{"test": 2432232314}`))
+	assert.Equal(t, "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE=", sig)
+
+	_, err = webhookSecret("whsec_%%%")
+	assert.Error(t, err)
+	_, err = webhookSecret("")
+	assert.Error(t, err)
+}
+
+// webhookEndpoint is a fake webhook receiver answering with each status in turn, and the last
+// one after that.
+type webhookEndpoint struct {
+	mu       sync.Mutex
+	statuses []int
+	header   http.Header
+	requests []*http.Request
+	bodies   []string
+}
+
+func (e *webhookEndpoint) client() *http.Client {
+	return NewTestClient(func(req *http.Request) *http.Response {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		body, _ := io.ReadAll(req.Body)
+		e.requests = append(e.requests, req)
+		e.bodies = append(e.bodies, string(body))
+		status := e.statuses[min(len(e.requests), len(e.statuses))-1]
+		header := e.header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(``)), Header: header}
+	})
+}
+
+func newTestWebhookDispatcher(t *testing.T, dir string, endpoint *webhookEndpoint, clock *fakeClock, attempts int) *WebhookDispatcher {
+	testTools := &Tools{HTTPClient: endpoint.client(), Clock: clock}
+	d, err := testTools.NewWebhookDispatcher(dir, "secret", WebhookOptions{
+		Retry: &RetryPolicy{MaxAttempts: attempts, BaseDelay: time.Minute, MaxDelay: time.Hour, Random: func() float64 { return 1 }},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func TestWebhookDispatcher_Deliver(t *testing.T) {
+	endpoint := &webhookEndpoint{statuses: []int{http.StatusNoContent}}
+	clock := newFakeClock()
+	d := newTestWebhookDispatcher(t, t.TempDir(), endpoint, clock, 3)
+
+	id, err := d.Enqueue("http://example.com/hook", map[string]any{"type": "order.created", "id": 1})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(id, "msg_"))
+
+	n, err := d.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	if assert.Len(t, endpoint.requests, 1) {
+		req := endpoint.requests[0]
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		assert.Equal(t, id, req.Header.Get("webhook-id"))
+		assert.Equal(t, "1704110400", req.Header.Get("webhook-timestamp"))
+		assert.Equal(t, SignWebhook([]byte("secret"), id, clock.Now(), []byte(endpoint.bodies[0])), req.Header.Get("webhook-signature"))
+		assert.Equal(t, `{"id":1,"type":"order.created"}`, endpoint.bodies[0])
+	}
+
+	queued, err := d.Queued()
+	assert.NoError(t, err)
+	assert.Empty(t, queued)
+
+	log, err := d.Deliveries(WebhookDeliveryQuery{})
+	assert.NoError(t, err)
+	if assert.Len(t, log, 1) {
+		assert.Equal(t, WebhookDelivery{MessageID: id, URL: "http://example.com/hook", Attempt: 1, Time: log[0].Time, StatusCode: 204, Outcome: WebhookDelivered}, log[0])
+	}
+}
+
+func TestWebhookDispatcher_Retry(t *testing.T) {
+	endpoint := &webhookEndpoint{statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK}}
+	clock := newFakeClock()
+	dir := t.TempDir()
+	d := newTestWebhookDispatcher(t, dir, endpoint, clock, 5)
+
+	id, _ := d.Enqueue("http://example.com/hook", "payload")
+
+	deliver := func(expected int) {
+		t.Helper()
+		n, err := d.DeliverDue(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, expected, n)
+	}
+
+	deliver(0)
+	queued, _ := d.Queued()
+	if assert.Len(t, queued, 1) {
+		assert.Equal(t, 1, queued[0].Attempts)
+		assert.Equal(t, clock.Now().Add(time.Minute), queued[0].NextAttempt)
+		assert.Equal(t, "503 Service Unavailable", queued[0].LastError)
+	}
+
+	// not due yet
+	deliver(0)
+	assert.Len(t, endpoint.requests, 1)
+
+	// the queue survives a restart, and the delay doubles
+	clock.Advance(time.Minute)
+	d = newTestWebhookDispatcher(t, dir, endpoint, clock, 5)
+	deliver(0)
+	clock.Advance(time.Minute)
+	deliver(0)
+	clock.Advance(time.Minute)
+	deliver(1)
+	assert.Len(t, endpoint.requests, 3)
+
+	// every attempt carries the same ID, with a fresh timestamp
+	assert.Equal(t, id, endpoint.requests[2].Header.Get("webhook-id"))
+	assert.NotEqual(t, endpoint.requests[0].Header.Get("webhook-timestamp"), endpoint.requests[2].Header.Get("webhook-timestamp"))
+
+	log, _ := d.Deliveries(WebhookDeliveryQuery{MessageID: id})
+	outcomes := make([]string, len(log))
+	for i, entry := range log {
+		outcomes[i] = entry.Outcome
+	}
+	assert.Equal(t, []string{WebhookRetrying, WebhookRetrying, WebhookDelivered}, outcomes)
+
+	failed, _ := d.Deliveries(WebhookDeliveryQuery{Failed: true, Limit: 1})
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, 500, failed[0].StatusCode)
+	}
+
+	recent, _ := d.Deliveries(WebhookDeliveryQuery{Since: clock.Now()})
+	assert.Len(t, recent, 1)
+	none, _ := d.Deliveries(WebhookDeliveryQuery{URL: "http://example.com/other"})
+	assert.Empty(t, none)
+}
+
+func TestWebhookDispatcher_RetryAfterOverflow(t *testing.T) {
+	endpoint := &webhookEndpoint{statuses: []int{http.StatusTooManyRequests}, header: http.Header{"Retry-After": {"99999999999"}}}
+	clock := newFakeClock()
+	d := newTestWebhookDispatcher(t, t.TempDir(), endpoint, clock, 5)
+
+	_, _ = d.Enqueue("http://example.com/hook", "payload")
+	_, err := d.DeliverDue(context.Background())
+	assert.NoError(t, err)
+
+	queued, _ := d.Queued()
+	if assert.Len(t, queued, 1) {
+		assert.Equal(t, clock.Now().Add(time.Hour), queued[0].NextAttempt)
+	}
+}
+
+func TestWebhookDispatcher_DeadLetters(t *testing.T) {
+	endpoint := &webhookEndpoint{statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusGone, http.StatusOK}}
+	clock := newFakeClock()
+	d := newTestWebhookDispatcher(t, t.TempDir(), endpoint, clock, 2)
+
+	first, _ := d.Enqueue("http://example.com/a", 1)
+	_, _ = d.DeliverDue(context.Background())
+	clock.Advance(time.Minute)
+	_, _ = d.DeliverDue(context.Background())
+
+	// 410 Gone moves a message to the dead-letter list at once
+	second, _ := d.Enqueue("http://example.com/b", 2)
+	_, _ = d.DeliverDue(context.Background())
+
+	queued, _ := d.Queued()
+	assert.Empty(t, queued)
+	dead, err := d.DeadLetters()
+	assert.NoError(t, err)
+	if assert.Len(t, dead, 2) {
+		assert.Equal(t, first, dead[0].ID)
+		assert.Equal(t, 2, dead[0].Attempts)
+		assert.Equal(t, second, dead[1].ID)
+		assert.Equal(t, "410 Gone", dead[1].LastError)
+	}
+
+	log, _ := d.Deliveries(WebhookDeliveryQuery{Failed: true})
+	assert.Len(t, log, 3)
+	assert.Equal(t, WebhookDead, log[2].Outcome)
+
+	assert.NoError(t, d.Redeliver(first))
+	assert.Error(t, d.Redeliver("../queue/"+first))
+	n, err := d.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	dead, _ = d.DeadLetters()
+	assert.Len(t, dead, 1)
+}
+
+func TestWebhookDispatcher_ConnectionError(t *testing.T) {
+	clock := newFakeClock()
+	testTools := &Tools{Clock: clock, HTTPClient: NewTestClient(func(req *http.Request) *http.Response { return nil })}
+	d, err := testTools.NewWebhookDispatcher(t.TempDir(), "whsec_c2VjcmV0")
+	assert.NoError(t, err)
+
+	_, _ = d.Enqueue("http://example.com/hook", nil)
+	_, err = d.DeliverDue(context.Background())
+	assert.NoError(t, err)
+
+	log, _ := d.Deliveries(WebhookDeliveryQuery{})
+	if assert.Len(t, log, 1) {
+		assert.Zero(t, log[0].StatusCode)
+		assert.NotEmpty(t, log[0].Error)
+		assert.Equal(t, WebhookRetrying, log[0].Outcome)
+	}
+}
+
+func TestWebhookDispatcher_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := newFakeClock()
+	testTools := &Tools{Clock: clock, HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+		cancel()
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(``)), Header: make(http.Header)}
+	})}
+	d, err := testTools.NewWebhookDispatcher(t.TempDir(), "secret")
+	assert.NoError(t, err)
+
+	_, _ = d.Enqueue("http://example.com/hook", nil)
+	assert.NoError(t, d.Run(ctx))
+
+	queued, _ := d.Queued()
+	assert.Empty(t, queued)
+}
+
+func TestWebhookDispatcher_Concurrency(t *testing.T) {
+	fastDone := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	clock := newFakeClock()
+	testTools := &Tools{Clock: clock, HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+		if req.URL.Host == "slow.example.com" {
+			// the slow endpoint answers only once the other one has been sent to
+			select {
+			case <-fastDone:
+			case <-time.After(5 * time.Second):
+			}
+		}
+		mu.Lock()
+		order = append(order, req.URL.Host+" "+req.Header.Get("webhook-id"))
+		mu.Unlock()
+		if req.URL.Host == "fast.example.com" {
+			close(fastDone)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(``)), Header: make(http.Header)}
+	})}
+	d, err := testTools.NewWebhookDispatcher(t.TempDir(), "secret", WebhookOptions{Concurrency: 2})
+	assert.NoError(t, err)
+
+	first, _ := d.Enqueue("http://slow.example.com/hook", 1)
+	clock.Advance(time.Second)
+	second, _ := d.Enqueue("http://slow.example.com/hook", 2)
+	clock.Advance(time.Second)
+	other, _ := d.Enqueue("http://fast.example.com/hook", 3)
+
+	n, err := d.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	// the fast endpoint did not wait for the slow one, whose messages kept their order
+	assert.Equal(t, []string{"fast.example.com " + other, "slow.example.com " + first, "slow.example.com " + second}, order)
+}
+
+func TestWebhookDispatcher_NotDue(t *testing.T) {
+	endpoint := &webhookEndpoint{statuses: []int{http.StatusServiceUnavailable}}
+	clock := newFakeClock()
+	dir := t.TempDir()
+	d := newTestWebhookDispatcher(t, dir, endpoint, clock, 3)
+
+	id, _ := d.Enqueue("http://example.com/hook", "payload")
+	_, _ = d.DeliverDue(context.Background())
+
+	// a message that is not due is not read again
+	path := filepath.Join(dir, "queue", id+".json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"id":`), 0644))
+	_, err := d.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.FileExists(t, path)
+	assert.Len(t, endpoint.requests, 1)
+}
+
+func TestWebhookDispatcher_CorruptMessages(t *testing.T) {
+	endpoint := &webhookEndpoint{statuses: []int{http.StatusOK}}
+	clock := newFakeClock()
+	dir := t.TempDir()
+	d := newTestWebhookDispatcher(t, dir, endpoint, clock, 3)
+
+	id, _ := d.Enqueue("http://example.com/hook", "payload")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "queue", "msg_bad.json"), []byte(`{"id":`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "queue", ".tmp-123"), []byte(`{"id":`), 0644))
+
+	var logged bytes.Buffer
+	testTools := &Tools{HTTPClient: endpoint.client(), Clock: clock}
+	d, err := testTools.NewWebhookDispatcher(dir, "secret", WebhookOptions{ErrorLog: log.New(&logged, "", 0)})
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "queue", ".tmp-123"))
+
+	n, err := d.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	if assert.Len(t, endpoint.requests, 1) {
+		assert.Equal(t, id, endpoint.requests[0].Header.Get("webhook-id"))
+	}
+
+	assert.FileExists(t, filepath.Join(dir, "corrupt", "queue-msg_bad.json"))
+	assert.Contains(t, logged.String(), "msg_bad.json")
+
+	queued, err := d.Queued()
+	assert.NoError(t, err)
+	assert.Empty(t, queued)
+}
+
+func TestWebhookDispatcher_CircuitOpen(t *testing.T) {
+	endpoint := &webhookEndpoint{statuses: []int{http.StatusServiceUnavailable, http.StatusOK}}
+	clock := newFakeClock()
+	testTools := &Tools{HTTPClient: endpoint.client(), Clock: clock, CircuitBreaker: &CircuitBreaker{FailureThreshold: 1, CoolDown: time.Hour}}
+	d, err := testTools.NewWebhookDispatcher(t.TempDir(), "secret", WebhookOptions{
+		Retry: &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Minute, Random: func() float64 { return 1 }},
+	})
+	assert.NoError(t, err)
+
+	_, _ = d.Enqueue("http://example.com/hook", nil)
+	_, _ = d.DeliverDue(context.Background())
+
+	// the circuit is open, so nothing is sent and the attempt is not counted
+	clock.Advance(time.Minute)
+	_, err = d.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, endpoint.requests, 1)
+	queued, _ := d.Queued()
+	if assert.Len(t, queued, 1) {
+		assert.Equal(t, 1, queued[0].Attempts)
+		assert.Equal(t, clock.Now().Add(time.Hour), queued[0].NextAttempt)
+		assert.Contains(t, queued[0].LastError, "circuit breaker")
+	}
+	log, _ := d.Deliveries(WebhookDeliveryQuery{})
+	assert.Len(t, log, 1)
+
+	clock.Advance(time.Hour)
+	n, err := d.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestWebhookDispatcher_LogRotation(t *testing.T) {
+	endpoint := &webhookEndpoint{statuses: []int{http.StatusOK}}
+	dir := t.TempDir()
+	testTools := &Tools{HTTPClient: endpoint.client(), Clock: newFakeClock()}
+	d, err := testTools.NewWebhookDispatcher(dir, "secret", WebhookOptions{MaxLogSize: 1000})
+	assert.NoError(t, err)
+
+	var ids []string
+	for i := 0; i < 20; i++ {
+		id, _ := d.Enqueue("http://example.com/hook", i)
+		ids = append(ids, id)
+		_, _ = d.DeliverDue(context.Background())
+	}
+
+	for _, name := range []string{"deliveries.jsonl", "deliveries.jsonl.1"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if assert.NoError(t, err) {
+			assert.LessOrEqual(t, info.Size(), int64(1000))
+		}
+	}
+
+	// the oldest entries are gone, and the rest are still in order
+	log, err := d.Deliveries(WebhookDeliveryQuery{})
+	assert.NoError(t, err)
+	assert.Less(t, len(log), 20)
+	assert.Equal(t, ids[len(ids)-1], log[len(log)-1].MessageID)
+	assert.Equal(t, ids[20-len(log)], log[0].MessageID)
+}
@@ -0,0 +1,132 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultWebhookTolerance is how far a webhook's timestamp may be from now when
+// WebhookVerifier.Tolerance is not set.
+const defaultWebhookTolerance = 5 * time.Minute
+
+// WebhookVerifier checks the signature of incoming webhooks for VerifyWebhook. Use one of the
+// presets, StandardWebhooks or GitHubWebhooks, to get one for a given sender; a WebhookVerifier
+// built directly uses the Standard Webhooks format.
+type WebhookVerifier struct {
+	Secret    string        // the signing secret shared with the sender
+	Tolerance time.Duration // how far a signed timestamp may be from now; 0 means 5 minutes
+
+	// verify checks the signature of body against the request headers, returning the signed
+	// timestamp, or the zero time if the format does not sign one.
+	verify func(v *WebhookVerifier, h http.Header, body []byte) (time.Time, error)
+}
+
+// StandardWebhooks returns a verifier for the Standard Webhooks format, as sent by
+// WebhookDispatcher: the Webhook-Id, Webhook-Timestamp and Webhook-Signature headers, the last
+// holding one or more space-separated "v1,<base64>" signatures. A secret in the form
+// "whsec_<base64>" is decoded first.
+func StandardWebhooks(secret string) *WebhookVerifier {
+	return &WebhookVerifier{Secret: secret, verify: verifyStandardWebhook}
+}
+
+// GitHubWebhooks returns a verifier for GitHub's format: the X-Hub-Signature-256 header holding
+// "sha256=<hex>", the HMAC-SHA256 of the body. GitHub does not sign a timestamp, so replays
+// cannot be refused by age; the X-GitHub-Delivery header can be used to discard duplicates.
+func GitHubWebhooks(secret string) *WebhookVerifier {
+	return &WebhookVerifier{Secret: secret, verify: verifyGitHubWebhook}
+}
+
+// VerifyWebhook reads the body of a webhook request, checks its signature with v and decodes it
+// into data, which may be nil to only verify it. The body is read as ReadJSON reads it, limited to
+// MaxJSONSize bytes, and the signature is compared in constant time. If the format signs a
+// timestamp, requests whose timestamp is further than v.Tolerance from now are refused, so that a
+// captured request cannot be replayed later. The body is then decoded with the same rules as
+// ReadJSON. A missing or wrong signature results in ErrWebhookSignature, and an old or future
+// timestamp in ErrWebhookTimestamp.
+func (t *Tools) VerifyWebhook(w http.ResponseWriter, r *http.Request, v *WebhookVerifier, data any) error {
+	body, err := t.readJSONBody(w, r)
+	if err != nil {
+		return err
+	}
+
+	verify := v.verify
+	if verify == nil {
+		verify = verifyStandardWebhook
+	}
+	signed, err := verify(v, r.Header, body)
+	if err != nil {
+		return err
+	}
+	if !signed.IsZero() {
+		tolerance := v.Tolerance
+		if tolerance <= 0 {
+			tolerance = defaultWebhookTolerance
+		}
+		if age := t.clock().Now().Sub(signed); age > tolerance || age < -tolerance {
+			return ErrWebhookTimestamp
+		}
+	}
+
+	if data == nil {
+		return nil
+	}
+
+	return t.decodeJSON(body, data)
+}
+
+// verifyStandardWebhook checks a Standard Webhooks signature.
+func verifyStandardWebhook(v *WebhookVerifier, h http.Header, body []byte) (time.Time, error) {
+	secret, err := webhookSecret(v.Secret)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	id, timestamp, signatures := h.Get(webhookIDHeader), h.Get(webhookTimestampHeader), h.Get(webhookSignatureHeader)
+	if id == "" || timestamp == "" || signatures == "" {
+		return time.Time{}, ErrWebhookSignature
+	}
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrWebhookTimestamp
+	}
+	signed := time.Unix(secs, 0)
+
+	expected := []byte(SignWebhook(secret, id, signed, body))
+	for _, sig := range strings.Fields(signatures) {
+		if hmac.Equal([]byte(sig), expected) {
+			return signed, nil
+		}
+	}
+
+	return time.Time{}, ErrWebhookSignature
+}
+
+// verifyGitHubWebhook checks a GitHub X-Hub-Signature-256 signature.
+func verifyGitHubWebhook(v *WebhookVerifier, h http.Header, body []byte) (time.Time, error) {
+	if v.Secret == "" {
+		return time.Time{}, errors.New("webhook secret must not be empty")
+	}
+
+	sig, ok := strings.CutPrefix(h.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok {
+		return time.Time{}, ErrWebhookSignature
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return time.Time{}, ErrWebhookSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(v.Secret))
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return time.Time{}, ErrWebhookSignature
+	}
+
+	return time.Time{}, nil
+}
@@ -0,0 +1,170 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type webhookEvent struct {
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+}
+
+var verifyStandardWebhookTests = []struct {
+	name        string
+	body        string
+	signature   string // "" means a valid signature
+	timestamp   time.Duration
+	noID        bool
+	expectedErr error
+}{
+	{name: "valid", body: `{"type":"order.created","id":1}`},
+	{name: "one of several signatures", body: `{"type":"order.created","id":1}`, signature: "v1,bm9wZQ== valid"},
+	{name: "wrong signature", body: `{"type":"order.created","id":1}`, signature: "v1,bm9wZQ==", expectedErr: ErrWebhookSignature},
+	{name: "missing signature", body: `{"type":"order.created","id":1}`, signature: " ", expectedErr: ErrWebhookSignature},
+	{name: "missing id", body: `{"type":"order.created","id":1}`, noID: true, expectedErr: ErrWebhookSignature},
+	{name: "within tolerance", body: `{"type":"order.created","id":1}`, timestamp: -4 * time.Minute},
+	{name: "too old", body: `{"type":"order.created","id":1}`, timestamp: -6 * time.Minute, expectedErr: ErrWebhookTimestamp},
+	{name: "in the future", body: `{"type":"order.created","id":1}`, timestamp: 6 * time.Minute, expectedErr: ErrWebhookTimestamp},
+	{name: "unknown field", body: `{"type":"order.created","id":1,"extra":true}`, expectedErr: ErrUnknownField},
+	{name: "bad json", body: `{"type":`, expectedErr: ErrBadJSON},
+}
+
+func TestTools_VerifyWebhook_Standard(t *testing.T) {
+	clock := newFakeClock()
+	testTools := Tools{Clock: clock}
+	secret := "whsec_c2VjcmV0"
+	key, _ := webhookSecret(secret)
+
+	for _, e := range verifyStandardWebhookTests {
+		t.Run(e.name, func(t *testing.T) {
+			signed := clock.Now().Add(e.timestamp)
+			req := httptest.NewRequest("POST", "/hooks", strings.NewReader(e.body))
+			if !e.noID {
+				req.Header.Set("webhook-id", "msg_1")
+			}
+			req.Header.Set("webhook-timestamp", strconv.FormatInt(signed.Unix(), 10))
+			valid := SignWebhook(key, "msg_1", signed, []byte(e.body))
+			switch e.signature {
+			case "":
+				req.Header.Set("webhook-signature", valid)
+			default:
+				req.Header.Set("webhook-signature", strings.ReplaceAll(e.signature, "valid", valid))
+			}
+
+			var event webhookEvent
+			err := testTools.VerifyWebhook(httptest.NewRecorder(), req, StandardWebhooks(secret), &event)
+			if e.expectedErr == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, webhookEvent{Type: "order.created", ID: 1}, event)
+			} else {
+				assert.True(t, errors.Is(err, e.expectedErr), "expected %v, got %v", e.expectedErr, err)
+				if errors.Is(e.expectedErr, ErrWebhookSignature) || errors.Is(e.expectedErr, ErrWebhookTimestamp) {
+					assert.Zero(t, event)
+				}
+			}
+		})
+	}
+}
+
+func TestTools_VerifyWebhook_Tolerance(t *testing.T) {
+	clock := newFakeClock()
+	testTools := Tools{Clock: clock}
+	signed := clock.Now().Add(-time.Hour)
+
+	req := httptest.NewRequest("POST", "/hooks", strings.NewReader(`{}`))
+	req.Header.Set("webhook-id", "msg_1")
+	req.Header.Set("webhook-timestamp", strconv.FormatInt(signed.Unix(), 10))
+	req.Header.Set("webhook-signature", SignWebhook([]byte("secret"), "msg_1", signed, []byte(`{}`)))
+
+	v := StandardWebhooks("secret")
+	v.Tolerance = 2 * time.Hour
+	assert.NoError(t, testTools.VerifyWebhook(httptest.NewRecorder(), req, v, nil))
+}
+
+func TestTools_VerifyWebhook_TooLarge(t *testing.T) {
+	testTools := Tools{MaxJSONSize: 10}
+	req := httptest.NewRequest("POST", "/hooks", strings.NewReader(`{"type":"order.created"}`))
+
+	err := testTools.VerifyWebhook(httptest.NewRecorder(), req, StandardWebhooks("secret"), nil)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestTools_VerifyWebhook_GitHub(t *testing.T) {
+	var testTools Tools
+	body := `{"type":"push","id":2}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+	valid := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	for signature, expectedErr := range map[string]error{
+		valid:                               nil,
+		"sha256=" + strings.Repeat("0", 64): ErrWebhookSignature,
+		"sha256=zz":                         ErrWebhookSignature,
+		"sha1=abc":                          ErrWebhookSignature,
+		"":                                  ErrWebhookSignature,
+	} {
+		req := httptest.NewRequest("POST", "/hooks", strings.NewReader(body))
+		if signature != "" {
+			req.Header.Set("X-Hub-Signature-256", signature)
+		}
+
+		var event webhookEvent
+		err := testTools.VerifyWebhook(httptest.NewRecorder(), req, GitHubWebhooks("secret"), &event)
+		if expectedErr == nil {
+			assert.NoError(t, err)
+			assert.Equal(t, webhookEvent{Type: "push", ID: 2}, event)
+		} else {
+			assert.ErrorIs(t, err, expectedErr, signature)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/hooks", strings.NewReader(body))
+	req.Header.Set("X-Hub-Signature-256", valid)
+	assert.Error(t, testTools.VerifyWebhook(httptest.NewRecorder(), req, GitHubWebhooks(""), nil))
+}
+
+func TestTools_VerifyWebhook_Dispatcher(t *testing.T) {
+	var received webhookEvent
+	var receiver Tools
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := receiver.VerifyWebhook(w, r, StandardWebhooks("whsec_c2VjcmV0"), &received); err != nil {
+			_ = receiver.ErrorJSON(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	var sender Tools
+	d, err := sender.NewWebhookDispatcher(t.TempDir(), "whsec_c2VjcmV0")
+	assert.NoError(t, err)
+	_, _ = d.Enqueue(server.URL, webhookEvent{Type: "order.paid", ID: 3})
+
+	n, err := d.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, webhookEvent{Type: "order.paid", ID: 3}, received)
+
+	// a webhook signed with another secret is refused
+	wrong, err := sender.NewWebhookDispatcher(t.TempDir(), "other")
+	assert.NoError(t, err)
+	_, _ = wrong.Enqueue(server.URL, webhookEvent{Type: "order.paid", ID: 4})
+	_, _ = wrong.DeliverDue(context.Background())
+
+	log, _ := wrong.Deliveries(WebhookDeliveryQuery{})
+	if assert.Len(t, log, 1) {
+		assert.Equal(t, http.StatusUnauthorized, log[0].StatusCode)
+	}
+}